@@ -1,3 +1,3 @@
 module github.com/bww/epl
 
-go 1.12
+go 1.18
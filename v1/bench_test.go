@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"os"
+	"testing"
+)
+
+type benchContext struct {
+	Name   string
+	Count  int
+	Ratio  float64
+	Tags   []string
+	Parent *benchContext
+}
+
+func (c *benchContext) Greeting() string {
+	return "Hello, " + c.Name
+}
+
+func (c *benchContext) Scale(v float64) float64 {
+	return v * c.Ratio
+}
+
+var benchSources = []struct {
+	name, source string
+}{
+	{"Literal", `60 * 60 * 24`},
+	{"Field", `Name == "Example"`},
+	{"NestedField", `Parent.Parent.Count > 10`},
+	{"Method", `Greeting == "Hello, Example"`},
+	{"MethodCall", `Scale(10) < 100 && Parent.Scale(2) > 0`},
+	{"Logic", `Count > 10 && Ratio < 1 || Name == "Other" && len(Tags) > 1`},
+	{"Arithmetic", `(Count * 2 + Ratio) / 3 - Parent.Count % 7`},
+	{"Index", `Tags[1] == "b" && Tags[0] + Tags[2] == "ac"`},
+	{"Builtin", `match("^[A-Z][a-z]+$", Name)`},
+}
+
+func benchmarkContext() *benchContext {
+	root := &benchContext{Name: "Root", Count: 100, Ratio: 0.25, Tags: []string{"x"}}
+	parent := &benchContext{Name: "Parent", Count: 50, Ratio: 0.5, Tags: []string{"y"}, Parent: root}
+	return &benchContext{Name: "Example", Count: 20, Ratio: 0.75, Tags: []string{"a", "b", "c"}, Parent: parent}
+}
+
+// Execute the parsed tree directly, node-by-node
+func BenchmarkInterpreted(b *testing.B) {
+	for _, e := range benchSources {
+		p, err := Compile(e.source)
+		if err != nil {
+			b.Fatalf("[%s] %v", e.source, err)
+		}
+		cxt := benchmarkContext()
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := p.tree.exec(&Runtime{Stdout: os.Stdout}, newContext(stdlib, cxt))
+				if err != nil {
+					b.Fatalf("[%s] %v", e.source, err)
+				}
+			}
+		})
+	}
+}
+
+// Execute the compiled program
+func BenchmarkCompiled(b *testing.B) {
+	for _, e := range benchSources {
+		p, err := Compile(e.source)
+		if err != nil {
+			b.Fatalf("[%s] %v", e.source, err)
+		}
+		cxt := benchmarkContext()
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := p.Exec(cxt)
+				if err != nil {
+					b.Fatalf("[%s] %v", e.source, err)
+				}
+			}
+		})
+	}
+}
+
+// Execute the program compiled to bytecode
+func BenchmarkBytecode(b *testing.B) {
+	for _, e := range benchSources {
+		p, err := CompileWithOptions(e.source, CompileOptions{Engine: EngineBytecode})
+		if err != nil {
+			b.Fatalf("[%s] %v", e.source, err)
+		}
+		cxt := benchmarkContext()
+		b.Run(e.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := p.Exec(cxt)
+				if err != nil {
+					b.Fatalf("[%s] %v", e.source, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,935 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+)
+
+/**
+ * Bytecode operations. Operands are described in terms of the fields of
+ * an instruction: a, b, and s (which is always the index of a span).
+ */
+type opcode uint8
+
+const (
+	opConst    opcode = iota // push consts[a]
+	opLoad                   // push the value of names[a] in the current context
+	opEnter                  // pop a value and push it onto the context as a frame
+	opLeave                  // pop the top frame from the context
+	opTruth                  // convert the top of the stack to a bool, as the operand of a logical operator
+	opOr                     // if the top of the stack is true jump to a, otherwise pop it
+	opAnd                    // if the top of the stack is false jump to a, otherwise pop it
+	opOperand                // check that the top of the stack is a valid left operand for arithmetic operator a
+	opArith                  // apply arithmetic operator a to the top two values; b is the span of the expression
+	opCompare                // apply relational operator a to the top two values; b is the span of the right operand
+	opIndex                  // subscript the second value by the top value; b is the span of the subscript
+	opCall                   // invoke the function names[a] with the arguments of calls[b]
+	opMethod                 // pop a receiver and invoke its method names[a] with the arguments of calls[b]
+	opMatch                  // invoke match() with the precompiled expression regexes[a] and the arguments of calls[b]
+	opFail                   // produce a runtime error with the message consts[a]
+	opLoadUUID               // like opLoad for the UUID identifier names[a], but push the UUID itself if it is undefined
+	opMember                 // like opLoad for the member names[a] of the value entered by a dereference; a step if b is set
+	opBool                   // like opTruth for an expression which is coerced on its own (see truthNode)
+	opMax
+)
+
+/**
+ * Determine if an instruction is counted as a step. Every node of the
+ * expression tree is evaluated by exactly one counted instruction, so a
+ * program takes as many steps when it is run as bytecode as it does on the
+ * other engines; the instructions which only support another, like opEnter
+ * and opLeave, are not counted.
+ */
+func (in instr) counted() bool {
+	switch in.op {
+	case opConst, opLoad, opLoadUUID, opEnter, opBool, opOr, opAnd, opArith, opCompare, opIndex, opCall, opMethod, opMatch:
+		return true
+	case opMember:
+		return in.b != 0
+	default:
+		return false
+	}
+}
+
+/**
+ * A single instruction
+ */
+type instr struct {
+	op      opcode
+	a, b, s uint32
+}
+
+/**
+ * A chunk of bytecode. The root expression of a program is chunk zero;
+ * function arguments are compiled to their own chunks so that they can be
+ * evaluated when, and if, the function being invoked requires them.
+ */
+type chunk struct {
+	code   []instr
+	depth  int // the maximum stack depth required
+	caches []memberCache
+}
+
+/**
+ * Compiled bytecode
+ */
+type bytecode struct {
+	opts    CompileOptions // the options the program was compiled with, as far as they are encoded
+	source  string
+	spans   []span
+	consts  []interface{}
+	names   []string
+	regexes []*regexp.Regexp
+	calls   [][]uint32 // chunks for the arguments to each call
+	chunks  []chunk
+	args    [][]executable // arguments for each call, derived from calls
+	uses    []string       // the library functions the program requires, when encoded
+}
+
+/**
+ * Compile an expression tree to bytecode
+ */
+func compileBytecode(e executable, opts CompileOptions) (*bytecode, error) {
+	c := &bytecodeCompiler{b: &bytecode{opts: opts, source: e.src().text}, spans: make(map[span]uint32)}
+	_, err := c.chunk(e)
+	if err != nil {
+		return nil, err
+	}
+	c.b.link()
+	return c.b, nil
+}
+
+/**
+ * Derive the runtime structures that are not serialized
+ */
+func (b *bytecode) link() {
+	for i := range b.chunks {
+		b.chunks[i].caches = make([]memberCache, len(b.chunks[i].code))
+	}
+	b.args = make([][]executable, len(b.calls))
+	for i, e := range b.calls {
+		args := make([]executable, len(e))
+		for j, c := range e {
+			args[j] = bytecodeArg{b, int(c)}
+		}
+		b.args[i] = args
+	}
+}
+
+/**
+ * Execute the bytecode
+ */
+func (b *bytecode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	return b.run(runtime, context, 0)
+}
+
+/**
+ * Run a chunk
+ */
+func (b *bytecode) run(runtime *Runtime, context *context, c int) (interface{}, error) {
+	ch := &b.chunks[c]
+
+	var buf [16]interface{}
+	stack := buf[:0]
+	if ch.depth > len(buf) {
+		stack = make([]interface{}, 0, ch.depth)
+	}
+
+	var err error
+	for pc := 0; pc < len(ch.code); pc++ {
+		in := ch.code[pc]
+		if in.counted() {
+			if err := runtime.step(b.spans[in.s]); err != nil {
+				return nil, err
+			}
+		}
+		switch in.op {
+
+		case opConst:
+			stack = append(stack, b.consts[in.a])
+
+		case opLoad:
+			v, err := context.lookup(runtime, b.spans[in.s], b.names[in.a], &ch.caches[pc])
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+
+		case opMember:
+			v, err := context.member(runtime, b.spans[in.s], b.names[in.a], &ch.caches[pc])
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+
+		case opLoadUUID:
+			v, err := context.lookup(runtime, b.spans[in.s], b.names[in.a], &ch.caches[pc])
+			if err != nil {
+				u, _ := uuidFromIdent(b.names[in.a]) // validated when the bytecode is loaded
+				if v, err = resolvedUUID(u, v, err); err != nil {
+					return nil, err
+				}
+			}
+			stack = append(stack, v)
+
+		case opEnter:
+			l := len(stack) - 1
+			context.push(stack[l])
+			stack = stack[:l]
+
+		case opLeave:
+			context.pop()
+
+		case opTruth, opBool:
+			l := len(stack) - 1
+			stack[l], err = asBool(b.spans[in.s], stack[l])
+			if err != nil {
+				return nil, err
+			}
+
+		case opOr:
+			l := len(stack) - 1
+			if stack[l].(bool) {
+				pc = int(in.a) - 1
+			} else {
+				stack = stack[:l]
+			}
+
+		case opAnd:
+			l := len(stack) - 1
+			if !stack[l].(bool) {
+				pc = int(in.a) - 1
+			} else {
+				stack = stack[:l]
+			}
+
+		case opOperand:
+			v := stack[len(stack)-1]
+			if _, ok := v.(string); !ok || tokenType(in.a) != tokenAdd {
+				_, err = asNumber(b.spans[in.s], v)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case opArith:
+			l := len(stack) - 1
+			lvi, rvi := stack[l-1], stack[l]
+			stack = stack[:l]
+			if lv, ok := lvi.(string); ok && tokenType(in.a) == tokenAdd {
+				rv, err := asString(b.spans[in.s], rvi)
+				if err != nil {
+					return nil, err
+				}
+				stack[l-1], err = runtime.concat(b.spans[in.b], lv, rv)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				lv, _ := asNumber(b.spans[in.s], lvi) // already checked by opOperand
+				rv, err := asNumber(b.spans[in.s], rvi)
+				if err != nil {
+					return nil, err
+				}
+				if tokenType(in.a) == tokenMod {
+					stack[l-1], err = modulo(runtime, b.spans[in.b], lvi, rvi)
+				} else {
+					stack[l-1], err = arithmetic(runtime, b.spans[in.b], tokenType(in.a), lv, rv)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case opCompare:
+			l := len(stack) - 1
+			stack[l-1], err = relational(tokenType(in.a), b.spans[in.s], b.spans[in.b], stack[l-1], stack[l])
+			if err != nil {
+				return nil, err
+			}
+			stack = stack[:l]
+
+		case opIndex:
+			l := len(stack) - 1
+			stack[l-1], err = subscript(b.spans[in.s], b.spans[in.b], stack[l-1], stack[l])
+			if err != nil {
+				return nil, err
+			}
+			stack = stack[:l]
+
+		case opCall:
+			v, err := invokeFunction(runtime, context, b.spans[in.s], nil, b.names[in.a], b.args[in.b])
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+
+		case opMethod:
+			l := len(stack) - 1
+			stack[l], err = b.invokeMethod(runtime, context, ch, pc, in, stack[l])
+			if err != nil {
+				return nil, err
+			}
+
+		case opMatch:
+			var v interface{}
+			args := b.args[in.b]
+			if isBuiltInMatch(runtime, context, b.spans[in.s]) {
+				v, err = args[1].exec(runtime, context)
+				if err == nil {
+					v, err = matchPrecompiled(b.spans[in.s], b.regexes[in.a], v)
+				}
+			} else {
+				v, err = invokeFunction(runtime, context, b.spans[in.s], nil, "match", args)
+			}
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+
+		case opFail:
+			return nil, runtimeErrorf(b.spans[in.s], "%v", b.consts[in.a])
+
+		default:
+			return nil, runtimeErrorf(b.spans[in.s], "Invalid instruction: %v", in.op)
+
+		}
+	}
+
+	return stack[len(stack)-1], nil
+}
+
+/**
+ * Invoke a method on a receiver
+ */
+func (b *bytecode) invokeMethod(runtime *Runtime, context *context, ch *chunk, pc int, in instr, liv interface{}) (interface{}, error) {
+	s, name, args := b.spans[in.s], b.names[in.a], b.args[in.b]
+	if liv == nil {
+		return invokeFunction(runtime, context, s, nil, name, args)
+	}
+	lrv := reflect.ValueOf(liv)
+	var f reflect.Value
+	if e := ch.caches[pc].resolve(lrv.Type(), name); e.method >= 0 {
+		f = lrv.Method(e.method)
+	}
+	if !f.IsValid() {
+		return nil, noSuchMethodError(s, name, lrv)
+	}
+	return callFunction(runtime, context, s, f, name, args)
+}
+
+/**
+ * A function argument which is evaluated by running a chunk of bytecode
+ */
+type bytecodeArg struct {
+	code  *bytecode
+	chunk int
+}
+
+/**
+ * Obtain the argument's src span. This is the span of the first
+ * instruction, which encompasses the argument expression.
+ */
+func (a bytecodeArg) src() span {
+	ch := a.code.chunks[a.chunk]
+	s := span{text: a.code.source, line: 1, column: 1}
+	for i, e := range ch.code {
+		if i == 0 {
+			s = a.code.spans[e.s]
+		} else {
+			s = encompass(s, a.code.spans[e.s])
+		}
+	}
+	return s
+}
+
+/**
+ * Execute
+ */
+func (a bytecodeArg) exec(runtime *Runtime, context *context) (interface{}, error) {
+	return a.code.run(runtime, context, a.chunk)
+}
+
+/**
+ * Print
+ */
+func (a bytecodeArg) print(w io.Writer, opts PrintOptions, state printState) error {
+	_, err := w.Write([]byte(state.Indent() + a.src().excerpt()))
+	return err
+}
+
+/**
+ * A bytecode compiler
+ */
+type bytecodeCompiler struct {
+	b     *bytecode
+	spans map[span]uint32
+}
+
+/**
+ * A chunk under construction
+ */
+type chunkEmitter struct {
+	code         []instr
+	depth, limit int
+}
+
+/**
+ * Emit an instruction which changes the stack depth by d
+ */
+func (c *chunkEmitter) emit(d int, op opcode, a, b, s uint32) int {
+	c.code = append(c.code, instr{op, a, b, s})
+	c.depth += d
+	if c.depth > c.limit {
+		c.limit = c.depth
+	}
+	return len(c.code) - 1
+}
+
+/**
+ * Compile an expression to a new chunk
+ */
+func (c *bytecodeCompiler) chunk(e executable) (uint32, error) {
+	n := len(c.b.chunks)
+	c.b.chunks = append(c.b.chunks, chunk{})
+	x := &chunkEmitter{}
+	err := c.compile(x, e)
+	if err != nil {
+		return 0, err
+	}
+	c.b.chunks[n] = chunk{code: x.code, depth: x.limit}
+	return uint32(n), nil
+}
+
+/**
+ * Obtain the index of a span
+ */
+func (c *bytecodeCompiler) span(s span) uint32 {
+	if i, ok := c.spans[s]; ok {
+		return i
+	}
+	i := uint32(len(c.b.spans))
+	c.b.spans = append(c.b.spans, s)
+	c.spans[s] = i
+	return i
+}
+
+/**
+ * Obtain the index of a constant
+ */
+func (c *bytecodeCompiler) constant(v interface{}) uint32 {
+	c.b.consts = append(c.b.consts, v)
+	return uint32(len(c.b.consts) - 1)
+}
+
+/**
+ * Obtain the index of a name
+ */
+func (c *bytecodeCompiler) name(n string) uint32 {
+	for i, e := range c.b.names {
+		if e == n {
+			return uint32(i)
+		}
+	}
+	c.b.names = append(c.b.names, n)
+	return uint32(len(c.b.names) - 1)
+}
+
+/**
+ * Compile the arguments of a call to chunks
+ */
+func (c *bytecodeCompiler) call(params []executable) (uint32, error) {
+	n := len(c.b.calls)
+	c.b.calls = append(c.b.calls, nil)
+	args := make([]uint32, len(params))
+	for i, e := range params {
+		a, err := c.chunk(e)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = a
+	}
+	c.b.calls[n] = args
+	return uint32(n), nil
+}
+
+/**
+ * Compile an expression into a chunk
+ */
+func (c *bytecodeCompiler) compile(x *chunkEmitter, e executable) error {
+	switch n := e.(type) {
+
+	case *literalNode:
+		x.emit(1, opConst, c.constant(n.value), 0, c.span(n.span))
+
+	case *identNode:
+		if n.member {
+			x.emit(1, opMember, c.name(n.ident), 1, c.span(n.span))
+		} else {
+			x.emit(1, opLoad, c.name(n.ident), 0, c.span(n.span))
+		}
+
+	case *uuidNode:
+		x.emit(1, opLoadUUID, c.name(n.ident), 0, c.span(n.span))
+
+	case *logicalOrNode:
+		return c.compileLogical(x, opOr, n.left, n.right)
+
+	case *logicalAndNode:
+		return c.compileLogical(x, opAnd, n.left, n.right)
+
+	case *truthNode:
+		err := c.compile(x, n.expr)
+		if err != nil {
+			return err
+		}
+		x.emit(0, opBool, 0, 0, c.span(n.expr.src()))
+
+	case *arithmeticNode:
+		err := c.compile(x, n.left)
+		if err != nil {
+			return err
+		}
+		x.emit(0, opOperand, uint32(n.op.which), 0, c.span(n.left.src()))
+		err = c.compile(x, n.right)
+		if err != nil {
+			return err
+		}
+		x.emit(-1, opArith, uint32(n.op.which), c.span(n.span), c.span(n.right.src()))
+
+	case *relationalNode:
+		err := c.compile(x, n.left)
+		if err != nil {
+			return err
+		}
+		err = c.compile(x, n.right)
+		if err != nil {
+			return err
+		}
+		x.emit(-1, opCompare, uint32(n.op.which), c.span(n.right.src()), c.span(n.left.src()))
+
+	case *derefNode:
+		err := c.compile(x, n.left)
+		if err != nil {
+			return err
+		}
+		x.emit(-1, opEnter, 0, 0, c.span(n.span))
+		switch v := n.right.(type) {
+		case *identNode:
+			x.emit(1, opMember, c.name(v.ident), 0, c.span(n.span))
+		case *derefNode, *indexNode, *invokeNode:
+			err = c.compile(x, v)
+			if err != nil {
+				return err
+			}
+		default:
+			x.emit(1, opFail, c.constant(fmt.Sprintf("Invalid right operand to . (dereference): %v (%T)", v, v)), 0, c.span(n.span))
+		}
+		x.emit(0, opLeave, 0, 0, c.span(n.span))
+
+	case *indexNode:
+		err := c.compile(x, n.left)
+		if err != nil {
+			return err
+		}
+		err = c.compile(x, n.right)
+		if err != nil {
+			return err
+		}
+		x.emit(-1, opIndex, 0, c.span(n.right.src()), c.span(n.span))
+
+	case *matchNode:
+		call, err := c.call(n.params)
+		if err != nil {
+			return err
+		}
+		c.b.regexes = append(c.b.regexes, n.expr)
+		x.emit(1, opMatch, uint32(len(c.b.regexes)-1), call, c.span(n.span))
+
+	case *invokeNode:
+		ident, ok := n.right.(*identNode)
+		if !ok {
+			x.emit(1, opFail, c.constant(fmt.Sprintf("Invalid node type for function call: %T", n.right)), 0, c.span(n.span))
+			return nil
+		}
+		call, err := c.call(n.params)
+		if err != nil {
+			return err
+		}
+		if n.left == nil {
+			x.emit(1, opCall, c.name(ident.ident), call, c.span(n.span))
+		} else {
+			err = c.compile(x, n.left)
+			if err != nil {
+				return err
+			}
+			x.emit(0, opMethod, c.name(ident.ident), call, c.span(n.span))
+		}
+
+	default:
+		return fmt.Errorf("Cannot compile %T to bytecode", e)
+
+	}
+	return nil
+}
+
+/**
+ * Compile a short-circuiting logical operator
+ */
+func (c *bytecodeCompiler) compileLogical(x *chunkEmitter, op opcode, left, right executable) error {
+	err := c.compile(x, left)
+	if err != nil {
+		return err
+	}
+	x.emit(0, opTruth, 0, 0, c.span(left.src()))
+	j := x.emit(-1, op, 0, 0, c.span(left.src()))
+	err = c.compile(x, right)
+	if err != nil {
+		return err
+	}
+	x.emit(0, opTruth, 0, 0, c.span(right.src()))
+	x.code[j].a = uint32(len(x.code))
+	return nil
+}
+
+/**
+ * Bytecode serialization
+ */
+const (
+	bytecodeMagic   = "EPLB"
+	bytecodeVersion = 2
+)
+
+const (
+	constNil byte = iota
+	constFalse
+	constTrue
+	constFloat
+	constInt
+	constString
+	constUint
+)
+
+/**
+ * Encode bytecode
+ */
+func (b *bytecode) MarshalBinary() ([]byte, error) {
+	w := &binaryWriter{}
+	w.buf.WriteString(bytecodeMagic)
+	w.uint(bytecodeVersion)
+	w.stdlib(encodeStdlib(b.opts.Stdlib))
+	w.limits(encodeLimits(b.opts))
+	w.names(b.uses)
+	w.string(b.source)
+
+	w.uint(uint64(len(b.spans)))
+	for _, e := range b.spans {
+		w.uint(uint64(e.offset))
+		w.uint(uint64(e.length))
+	}
+
+	w.uint(uint64(len(b.consts)))
+	for _, e := range b.consts {
+		switch v := e.(type) {
+		case nil:
+			w.buf.WriteByte(constNil)
+		case bool:
+			if v {
+				w.buf.WriteByte(constTrue)
+			} else {
+				w.buf.WriteByte(constFalse)
+			}
+		case float64:
+			w.buf.WriteByte(constFloat)
+			w.uint(math.Float64bits(v))
+		case int64:
+			w.buf.WriteByte(constInt)
+			w.int(v)
+		case uint64:
+			w.buf.WriteByte(constUint)
+			w.uint(v)
+		case string:
+			w.buf.WriteByte(constString)
+			w.string(v)
+		default:
+			return nil, fmt.Errorf("Cannot encode constant of type %T", e)
+		}
+	}
+
+	w.uint(uint64(len(b.names)))
+	for _, e := range b.names {
+		w.string(e)
+	}
+
+	w.uint(uint64(len(b.regexes)))
+	for _, e := range b.regexes {
+		w.string(e.String())
+	}
+
+	w.uint(uint64(len(b.calls)))
+	for _, e := range b.calls {
+		w.uint(uint64(len(e)))
+		for _, c := range e {
+			w.uint(uint64(c))
+		}
+	}
+
+	w.uint(uint64(len(b.chunks)))
+	for _, e := range b.chunks {
+		w.uint(uint64(e.depth))
+		w.uint(uint64(len(e.code)))
+		for _, c := range e.code {
+			w.buf.WriteByte(byte(c.op))
+			w.uint(uint64(c.a))
+			w.uint(uint64(c.b))
+			w.uint(uint64(c.s))
+		}
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+/**
+ * Decode bytecode. The bytecode is validated so that malformed input
+ * produces an error rather than a program which fails when it is run.
+ */
+func (b *bytecode) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(bytecodeMagic)) {
+		return fmt.Errorf("Invalid bytecode: bad header")
+	}
+
+	r := &binaryReader{r: bytes.NewReader(data[len(bytecodeMagic):])}
+	if v := r.uint(); r.err == nil && v != bytecodeVersion {
+		return fmt.Errorf("Unsupported bytecode version: %d", v)
+	}
+	b.opts = CompileOptions{Engine: EngineBytecode, Stdlib: r.stdlib().options()}
+	r.limits().apply(&b.opts)
+	b.uses = r.names()
+	b.source = r.string()
+
+	pos := newPositioner(b.source)
+	b.spans = make([]span, r.count())
+	for i := range b.spans {
+		o, l := r.uint(), r.uint()
+		if o > uint64(len(b.source)) || l > uint64(len(b.source))-o {
+			r.fail("span out of range")
+		}
+		b.spans[i] = pos.span(int(o), int(l))
+	}
+
+	b.consts = make([]interface{}, r.count())
+	for i := range b.consts {
+		switch t := r.byte(); t {
+		case constNil:
+			b.consts[i] = nil
+		case constFalse:
+			b.consts[i] = false
+		case constTrue:
+			b.consts[i] = true
+		case constFloat:
+			b.consts[i] = math.Float64frombits(r.uint())
+		case constInt:
+			b.consts[i] = r.int()
+		case constString:
+			b.consts[i] = r.string()
+		case constUint:
+			b.consts[i] = r.uint()
+		default:
+			r.fail("invalid constant")
+		}
+	}
+
+	b.names = make([]string, r.count())
+	for i := range b.names {
+		b.names[i] = r.string()
+	}
+
+	b.regexes = make([]*regexp.Regexp, r.count())
+	for i := range b.regexes {
+		x, err := regexes.compile(r.string())
+		if err != nil {
+			r.fail("invalid regular expression")
+		}
+		b.regexes[i] = x
+	}
+
+	b.calls = make([][]uint32, r.count())
+	for i := range b.calls {
+		args := make([]uint32, r.count())
+		for j := range args {
+			args[j] = uint32(r.uint())
+		}
+		b.calls[i] = args
+	}
+
+	b.chunks = make([]chunk, r.count())
+	for i := range b.chunks {
+		depth := r.count()
+		code := make([]instr, r.count())
+		for j := range code {
+			code[j] = instr{opcode(r.byte()), uint32(r.uint()), uint32(r.uint()), uint32(r.uint())}
+		}
+		b.chunks[i] = chunk{code: code, depth: depth}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.r.Len() > 0 {
+		return fmt.Errorf("Invalid bytecode: trailing data")
+	}
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	b.link()
+	return nil
+}
+
+/**
+ * Validate bytecode. Every operand must refer to a valid table entry,
+ * every chunk must leave exactly one value on the stack, never underflow
+ * it, and balance the context frames it enters; and calls may only refer to
+ * chunks that follow the chunk making the call, so that evaluation cannot
+ * recurse.
+ */
+func (b *bytecode) validate() error {
+	if len(b.chunks) < 1 {
+		return fmt.Errorf("Invalid bytecode: no code")
+	}
+	for i, ch := range b.chunks {
+		if len(ch.code) < 1 {
+			return fmt.Errorf("Invalid bytecode: chunk %d is empty", i)
+		}
+		type state struct{ depth, frames int }
+		var cur state
+		jumps := make(map[int]state)
+		for pc, in := range ch.code {
+			if j, ok := jumps[pc]; ok && j != cur {
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: inconsistent stack at jump target", i, pc)
+			}
+			if int(in.s) >= len(b.spans) {
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: span out of range", i, pc)
+			}
+			var pop, push, frames int
+			switch in.op {
+			case opConst, opFail:
+				if int(in.a) >= len(b.consts) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: constant out of range", i, pc)
+				}
+				push = 1
+			case opLoad, opLoadUUID, opMember:
+				if int(in.a) >= len(b.names) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: name out of range", i, pc)
+				}
+				if _, err := uuidFromIdent(b.names[in.a]); in.op == opLoadUUID && err != nil {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: invalid UUID identifier", i, pc)
+				}
+				push = 1
+			case opEnter:
+				pop, frames = 1, 1
+			case opLeave:
+				frames = -1
+			case opTruth, opBool, opOperand:
+				pop, push = 1, 1
+			case opOr, opAnd:
+				if int(in.a) <= pc || int(in.a) > len(ch.code) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: jump out of range", i, pc)
+				}
+				if pc < 1 || ch.code[pc-1].op != opTruth {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: jump on non-boolean", i, pc)
+				}
+				if _, ok := jumps[pc]; ok {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: jump to jump", i, pc)
+				}
+				if j, ok := jumps[int(in.a)]; ok && j != cur {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: inconsistent stack at jump target", i, pc)
+				}
+				jumps[int(in.a)] = cur
+				pop = 1
+			case opArith, opCompare, opIndex:
+				if int(in.b) >= len(b.spans) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: span out of range", i, pc)
+				}
+				pop, push = 2, 1
+			case opCall, opMethod, opMatch:
+				if in.op == opMatch {
+					if int(in.a) >= len(b.regexes) {
+						return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: expression out of range", i, pc)
+					}
+				} else if int(in.a) >= len(b.names) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: name out of range", i, pc)
+				}
+				if int(in.b) >= len(b.calls) {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: call out of range", i, pc)
+				}
+				args := b.calls[in.b]
+				if in.op == opMatch && len(args) != 2 {
+					return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: match requires 2 arguments", i, pc)
+				}
+				for _, e := range args {
+					if int(e) <= i || int(e) >= len(b.chunks) {
+						return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: argument out of range", i, pc)
+					}
+				}
+				if in.op == opMethod {
+					pop = 1
+				}
+				push = 1
+			default:
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: invalid operation %d", i, pc, in.op)
+			}
+			if cur.depth -= pop; cur.depth < 0 {
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: stack underflow", i, pc)
+			}
+			cur.depth += push
+			if cur.depth > ch.depth {
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: stack overflow", i, pc)
+			}
+			if cur.frames += frames; cur.frames < 0 {
+				return fmt.Errorf("Invalid bytecode: chunk %d, instruction %d: unbalanced context frames", i, pc)
+			}
+		}
+		if j, ok := jumps[len(ch.code)]; ok && j != cur {
+			return fmt.Errorf("Invalid bytecode: chunk %d: inconsistent stack at jump target", i)
+		}
+		if cur.depth != 1 || cur.frames != 0 {
+			return fmt.Errorf("Invalid bytecode: chunk %d leaves %d values on the stack and %d context frames", i, cur.depth, cur.frames)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,441 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+/**
+ * A compiled expression. Evaluators are produced from an expression tree by
+ * compile() and produce the same results as executing the tree directly,
+ * but without dispatching through the executable interface at every node
+ * and with struct member lookups cached at each site.
+ */
+type evaluator func(*Runtime, *context) (interface{}, error)
+
+/**
+ * Compile an expression tree to an evaluator
+ */
+func compile(e executable) evaluator {
+	switch n := e.(type) {
+	case *literalNode:
+		return compileLiteral(n)
+	case *identNode:
+		return compileIdent(n)
+	case *uuidNode:
+		return compileUUID(n)
+	case *logicalOrNode:
+		return compileLogicalOr(n)
+	case *logicalAndNode:
+		return compileLogicalAnd(n)
+	case *truthNode:
+		return compileTruth(n)
+	case *arithmeticNode:
+		return compileArithmetic(n)
+	case *relationalNode:
+		return compileRelational(n)
+	case *derefNode:
+		return compileDeref(n)
+	case *indexNode:
+		return compileIndex(n)
+	case *matchNode:
+		return compileMatch(n)
+	case *invokeNode:
+		return compileInvoke(n)
+	default:
+		return e.exec
+	}
+}
+
+/**
+ * Compile a list of expressions. The results are executables so that they
+ * can be passed to functions that accept parameters as expressions.
+ */
+func compileList(l []executable) []executable {
+	c := make([]executable, len(l))
+	for i, e := range l {
+		c[i] = compiledNode{e, compile(e)}
+	}
+	return c
+}
+
+/**
+ * Compile a literal
+ */
+func compileLiteral(n *literalNode) evaluator {
+	v := n.value
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+/**
+ * Compile an identifier
+ */
+func compileIdent(n *identNode) evaluator {
+	m := &memberCache{}
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		if n.member {
+			return context.member(runtime, n.span, n.ident, m)
+		}
+		return context.lookup(runtime, n.span, n.ident, m)
+	}
+}
+
+/**
+ * Compile a UUID identifier
+ */
+func compileUUID(n *uuidNode) evaluator {
+	m := &memberCache{}
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		v, err := context.lookup(runtime, n.span, n.ident, m)
+		return resolvedUUID(n.value, v, err)
+	}
+}
+
+/**
+ * Compile a logical OR
+ */
+func compileLogicalOr(n *logicalOrNode) evaluator {
+	left, right := compile(n.left), compile(n.right)
+	ls, rs := n.left.src(), n.right.src()
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		lvi, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		lv, err := asBool(ls, lvi)
+		if err != nil {
+			return nil, err
+		}
+		if lv {
+			return true, nil
+		}
+		rvi, err := right(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(rs, rvi)
+	}
+}
+
+/**
+ * Compile a logical AND
+ */
+func compileLogicalAnd(n *logicalAndNode) evaluator {
+	left, right := compile(n.left), compile(n.right)
+	ls, rs := n.left.src(), n.right.src()
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		lvi, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		lv, err := asBool(ls, lvi)
+		if err != nil {
+			return nil, err
+		}
+		if !lv {
+			return false, nil
+		}
+		rvi, err := right(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(rs, rvi)
+	}
+}
+
+/**
+ * Compile a boolean coercion
+ */
+func compileTruth(n *truthNode) evaluator {
+	expr := compile(n.expr)
+	s := n.expr.src()
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		v, err := expr(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(s, v)
+	}
+}
+
+/**
+ * Compile an arithmetic expression
+ */
+func compileArithmetic(n *arithmeticNode) evaluator {
+	left, right := compile(n.left), compile(n.right)
+	ls, rs := n.left.src(), n.right.src()
+	op := n.op.which
+	concat := op == tokenAdd
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		lvi, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		if concat {
+			if lv, ok := lvi.(string); ok {
+				rvi, err := right(runtime, context)
+				if err != nil {
+					return nil, err
+				}
+				rv, err := asString(rs, rvi)
+				if err != nil {
+					return nil, err
+				}
+				return runtime.concat(n.span, lv, rv)
+			}
+		}
+		lv, err := asNumber(ls, lvi)
+		if err != nil {
+			return nil, err
+		}
+		rvi, err := right(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := asNumber(rs, rvi)
+		if err != nil {
+			return nil, err
+		}
+		if op == tokenMod {
+			return modulo(runtime, n.span, lvi, rvi)
+		}
+		return arithmetic(runtime, n.span, op, lv, rv)
+	}
+}
+
+/**
+ * Compile a relational expression
+ */
+func compileRelational(n *relationalNode) evaluator {
+	left, right := compile(n.left), compile(n.right)
+	ls, rs := n.left.src(), n.right.src()
+	op := n.op.which
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		lvi, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		rvi, err := right(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return relational(op, ls, rs, lvi, rvi)
+	}
+}
+
+/**
+ * Compile a dereference
+ */
+func compileDeref(n *derefNode) evaluator {
+	var right evaluator
+	switch v := n.right.(type) {
+	case *identNode:
+		m := &memberCache{}
+		right = func(runtime *Runtime, context *context) (interface{}, error) {
+			return context.member(runtime, n.span, v.ident, m)
+		}
+	case *derefNode, *indexNode, *invokeNode:
+		right = compile(v)
+	default:
+		return n.exec // produces the appropriate error
+	}
+	left := compile(n.left)
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		v, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		context.push(v)
+		z, err := right(runtime, context)
+		context.pop()
+		return z, err
+	}
+}
+
+/**
+ * Compile a subscript
+ */
+func compileIndex(n *indexNode) evaluator {
+	left, right := compile(n.left), compile(n.right)
+	rs := n.right.src()
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		lv, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := right(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return subscript(n.span, rs, lv, sub)
+	}
+}
+
+/**
+ * Compile a match() with a precompiled expression
+ */
+func compileMatch(n *matchNode) evaluator {
+	general := compileInvoke(&n.invokeNode)
+	arg := compile(n.params[1])
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if !isBuiltInMatch(runtime, context, n.span) {
+			return general(runtime, context)
+		}
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		v, err := arg(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		return matchPrecompiled(n.span, n.expr, v)
+	}
+}
+
+/**
+ * Compile a function or method invocation
+ */
+func compileInvoke(n *invokeNode) evaluator {
+	ident, ok := n.right.(*identNode)
+	if !ok {
+		return n.exec // produces the appropriate error
+	}
+
+	name := ident.ident
+	params := compileList(n.params)
+	if n.left == nil {
+		return func(runtime *Runtime, context *context) (interface{}, error) {
+			if err := runtime.step(n.span); err != nil {
+				return nil, err
+			}
+			return invokeFunction(runtime, context, n.span, nil, name, params)
+		}
+	}
+
+	left := compile(n.left)
+	m := &memberCache{}
+	return func(runtime *Runtime, context *context) (interface{}, error) {
+		if err := runtime.step(n.span); err != nil {
+			return nil, err
+		}
+		liv, err := left(runtime, context)
+		if err != nil {
+			return nil, err
+		}
+		if liv == nil {
+			return invokeFunction(runtime, context, n.span, nil, name, params)
+		}
+		lrv := reflect.ValueOf(liv)
+		var f reflect.Value
+		if e := m.resolve(lrv.Type(), name); e.method >= 0 {
+			f = lrv.Method(e.method)
+		}
+		if !f.IsValid() {
+			return nil, noSuchMethodError(n.span, name, lrv)
+		}
+		return callFunction(runtime, context, n.span, f, name, params)
+	}
+}
+
+/**
+ * A node which executes a compiled expression in place of the node it was
+ * compiled from. The original node is retained for its span and so that it
+ * can be printed.
+ */
+type compiledNode struct {
+	executable
+	eval evaluator
+}
+
+/**
+ * Execute
+ */
+func (n compiledNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	return n.eval(runtime, context)
+}
+
+/**
+ * A cache of struct member resolutions at a single site in a program. Most
+ * sites only ever encounter one concrete type, so the most recent
+ * resolution is retained in front of the shared type cache, including a
+ * name which is not a member, which the shared cache does not retain. The
+ * cache is safe for concurrent use.
+ */
+type memberCache struct {
+	last atomic.Value // *memberEntry
+}
+
+/**
+ * Resolve a member of the provided type, which may be a struct or a pointer
+ * to a struct
+ */
+func (m *memberCache) resolve(t reflect.Type, name string) *memberEntry {
+	if e, ok := m.last.Load().(*memberEntry); ok && e.typ == t {
+		return e
+	}
+	e := typeInfoOf(t).member(name)
+	m.last.Store(e)
+	return e
+}
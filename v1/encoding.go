@@ -0,0 +1,1058 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+/**
+ * Program encoding. Programs are encoded as the expression tree produced
+ * by the parser along with the source it was parsed from and the options
+ * it was compiled with. Decoding a program does not parse the source
+ * again, but the tree is validated and prepared for execution as usual.
+ *
+ * The JSON form is intended to be readable by other tools and looks like:
+ *
+ *   {
+ *     "version": 2,
+ *     "engine": "compiled",
+ *     "stdlib": {"sandboxed": true, "allow": null},
+ *     "limits": {"maxSourceLength": 4096},
+ *     "source": "a.b == 1",
+ *     "root": {
+ *       "type": "relational", "op": "==", "span": [0, 8],
+ *       "left": {
+ *         "type": "deref", "span": [0, 2],
+ *         "left": {"type": "ident", "name": "a", "span": [0, 1]},
+ *         "right": {"type": "ident", "name": "b", "span": [2, 1]}
+ *       },
+ *       "right": {"type": "literal", "kind": "number", "value": 1, "span": [7, 1]}
+ *     }
+ *   }
+ *
+ * Spans are [offset, length] pairs in bytes of the source. Node types are
+ * "or", "and", "relational", "arithmetic", "deref", "index", "invoke",
+ * "ident", "uuid" (a UUID identifier), and "literal". Literal kinds are
+ * "nil", "bool", "number", and "string". The standard library options are
+ * omitted when every builtin is available; otherwise they are restored when
+ * the program is decoded, so that a program which is restricted remains so.
+ * Likewise the compile limits, which are omitted when none are set. The
+ * source and tree are checked against the limits when they are decoded.
+ *
+ * Libraries contain Go functions and are not encoded. Instead, "uses" lists
+ * the library functions the program refers to, and a decoded program which
+ * uses any fails to execute unless ExecOptions.Libraries provides them. The
+ * Schema and Result are not encoded either; they are only used to check the
+ * program when it is compiled.
+ */
+const (
+	encodingVersion = 2
+	encodingMagic   = "EPLP"
+	encodingDepth   = 10000 // the maximum depth of a decoded tree
+)
+
+/**
+ * An encoded program
+ */
+type encodedProgram struct {
+	Version int            `json:"version"`
+	Engine  string         `json:"engine"`
+	Stdlib  *encodedStdlib `json:"stdlib,omitempty"`
+	Limits  *encodedLimits `json:"limits,omitempty"`
+	Uses    []string       `json:"uses,omitempty"`
+	Source  string         `json:"source"`
+	Root    *encodedNode   `json:"root"`
+}
+
+/**
+ * Encoded standard library options. A nil allow list, which permits every
+ * builtin, is distinct from an empty one, which permits none.
+ */
+type encodedStdlib struct {
+	Sandboxed bool     `json:"sandboxed"`
+	Allow     []string `json:"allow"`
+}
+
+/**
+ * Encode standard library options
+ */
+func encodeStdlib(o *StdlibOptions) *encodedStdlib {
+	if o == nil {
+		return nil
+	}
+	return &encodedStdlib{o.Sandboxed, o.Allow}
+}
+
+/**
+ * Decode standard library options
+ */
+func (e *encodedStdlib) options() *StdlibOptions {
+	if e == nil {
+		return nil
+	}
+	return &StdlibOptions{Sandboxed: e.Sandboxed, Allow: e.Allow}
+}
+
+/**
+ * Encoded compile limits
+ */
+type encodedLimits struct {
+	MaxSourceLength     int `json:"maxSourceLength,omitempty"`
+	MaxDepth            int `json:"maxDepth,omitempty"`
+	MaxNodes            int `json:"maxNodes,omitempty"`
+	MaxIdentifierLength int `json:"maxIdentifierLength,omitempty"`
+}
+
+/**
+ * Encode the compile limits of the provided options
+ */
+func encodeLimits(o CompileOptions) *encodedLimits {
+	e := &encodedLimits{o.MaxSourceLength, o.MaxDepth, o.MaxNodes, o.MaxIdentifierLength}
+	if *e == (encodedLimits{}) {
+		return nil
+	}
+	return e
+}
+
+/**
+ * Apply decoded compile limits to the provided options
+ */
+func (e *encodedLimits) apply(o *CompileOptions) {
+	if e != nil {
+		o.MaxSourceLength, o.MaxDepth, o.MaxNodes, o.MaxIdentifierLength = e.MaxSourceLength, e.MaxDepth, e.MaxNodes, e.MaxIdentifierLength
+	}
+}
+
+/**
+ * An encoded expression tree node
+ */
+type encodedNode struct {
+	Type  string         `json:"type"`
+	Op    string         `json:"op,omitempty"`
+	Name  string         `json:"name,omitempty"`
+	Kind  string         `json:"kind,omitempty"`
+	Value interface{}    `json:"value,omitempty"`
+	Span  [2]int         `json:"span"`
+	Left  *encodedNode   `json:"left,omitempty"`
+	Right *encodedNode   `json:"right,omitempty"`
+	Args  []*encodedNode `json:"args,omitempty"`
+}
+
+/**
+ * Node types, in the order of their binary tags
+ */
+var encodedNodeTypes = []string{"or", "and", "relational", "arithmetic", "deref", "index", "invoke", "ident", "literal", "uuid"}
+
+/**
+ * Literal kinds, in the order of their binary tags
+ */
+var encodedLiteralKinds = []string{"nil", "bool", "number", "string"}
+
+/**
+ * Operators
+ */
+var encodedOperators = map[tokenType]string{
+	tokenAdd:          "+",
+	tokenSub:          "-",
+	tokenMul:          "*",
+	tokenDiv:          "/",
+	tokenMod:          "%",
+	tokenEqual:        "==",
+	tokenNotEqual:     "!=",
+	tokenLess:         "<",
+	tokenLessEqual:    "<=",
+	tokenGreater:      ">",
+	tokenGreaterEqual: ">=",
+	tokenIn:           "in",
+}
+
+/**
+ * Encode a program as JSON
+ */
+func (p *Program) MarshalJSON() ([]byte, error) {
+	e, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+/**
+ * Decode a program from JSON. Libraries, the Schema, and the Result are
+ * not restored; see the description of the encoding above.
+ */
+func (p *Program) UnmarshalJSON(data []byte) error {
+	e := &encodedProgram{}
+	err := json.Unmarshal(data, e)
+	if err != nil {
+		return err
+	}
+	return p.decode(e)
+}
+
+/**
+ * Encode a program in binary form
+ */
+func (p *Program) MarshalBinary() ([]byte, error) {
+	e, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+	w := &binaryWriter{}
+	w.buf.WriteString(encodingMagic)
+	w.uint(uint64(e.Version))
+	w.string(e.Engine)
+	w.stdlib(e.Stdlib)
+	w.limits(e.Limits)
+	w.names(e.Uses)
+	w.string(e.Source)
+	w.node(e.Root)
+	return w.buf.Bytes(), nil
+}
+
+/**
+ * Decode a program from binary form. Libraries, the Schema, and the Result
+ * are not restored; see the description of the encoding above.
+ */
+func (p *Program) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(encodingMagic)) {
+		return fmt.Errorf("Invalid encoding: bad header")
+	}
+	r := &binaryReader{r: bytes.NewReader(data[len(encodingMagic):])}
+	e := &encodedProgram{Version: int(r.uint())}
+	if r.err == nil && e.Version != encodingVersion {
+		return fmt.Errorf("Unsupported encoding version: %d", e.Version)
+	}
+	e.Engine = r.string()
+	e.Stdlib = r.stdlib()
+	e.Limits = r.limits()
+	e.Uses = r.names()
+	e.Source = r.string()
+	e.Root = r.node(0)
+	if r.err != nil {
+		return r.err
+	}
+	if r.r.Len() > 0 {
+		return fmt.Errorf("Invalid encoding: trailing data")
+	}
+	return p.decode(e)
+}
+
+/**
+ * Produce the encoded form of a program
+ */
+func (p *Program) encode() (*encodedProgram, error) {
+	if p.tree == nil {
+		return nil, fmt.Errorf("Program has no expression tree")
+	}
+	root, err := encodeNode(p.tree)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedProgram{
+		Version: encodingVersion,
+		Engine:  p.opts.Engine.String(),
+		Stdlib:  encodeStdlib(p.opts.Stdlib),
+		Limits:  encodeLimits(p.opts),
+		Uses:    p.functions(),
+		Source:  p.tree.src().text,
+		Root:    root,
+	}, nil
+}
+
+/**
+ * Produce a program from its encoded form
+ */
+func (p *Program) decode(e *encodedProgram) error {
+	if e.Version != encodingVersion {
+		return fmt.Errorf("Unsupported encoding version: %d", e.Version)
+	}
+
+	opts := CompileOptions{Stdlib: e.Stdlib.options()}
+	e.Limits.apply(&opts)
+	switch e.Engine {
+	case EngineCompiled.String():
+		opts.Engine = EngineCompiled
+	case EngineInterpreted.String():
+		opts.Engine = EngineInterpreted
+	case EngineBytecode.String():
+		opts.Engine = EngineBytecode
+	default:
+		return fmt.Errorf("Unsupported engine: %v", e.Engine)
+	}
+
+	if opts.MaxSourceLength > 0 && len(e.Source) > opts.MaxSourceLength {
+		return errorf(KindCompile, span{}, "Source is too long: %d bytes (maximum: %d)", len(e.Source), opts.MaxSourceLength)
+	}
+
+	tree, err := decodeNode(newPositioner(e.Source), e.Root, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	l := &limiter{opts: opts, max: opts.MaxDepth}
+	if l.max < 1 {
+		l.max = DefaultMaxDepth
+	}
+	if err := l.check(tree, 1); err != nil {
+		return err
+	}
+
+	for _, e := range e.Uses {
+		if !isIdentifier(e) {
+			return fmt.Errorf("Invalid encoding: invalid function name: %q", e)
+		}
+	}
+
+	x, err := newProgram(tree, opts)
+	if err != nil {
+		return err
+	}
+	x.uses = e.Uses
+
+	*p = *x
+	return nil
+}
+
+/**
+ * Encode an expression tree node
+ */
+func encodeNode(e executable) (*encodedNode, error) {
+	var err error
+	s := e.src()
+	x := &encodedNode{Span: [2]int{s.offset, s.length}}
+
+	switch n := e.(type) {
+	case *logicalOrNode:
+		x.Type = "or"
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *logicalAndNode:
+		x.Type = "and"
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *relationalNode:
+		x.Type, x.Op = "relational", encodedOperators[n.op.which]
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *arithmeticNode:
+		x.Type, x.Op = "arithmetic", encodedOperators[n.op.which]
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *derefNode:
+		x.Type = "deref"
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *indexNode:
+		x.Type = "index"
+		x.Left, x.Right, err = encodePair(n.left, n.right)
+	case *invokeNode:
+		x.Type = "invoke"
+		if n.left != nil {
+			x.Left, err = encodeNode(n.left)
+			if err != nil {
+				return nil, err
+			}
+		}
+		x.Right, err = encodeNode(n.right)
+		if err != nil {
+			return nil, err
+		}
+		x.Args = make([]*encodedNode, len(n.params))
+		for i, a := range n.params {
+			x.Args[i], err = encodeNode(a)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case *identNode:
+		x.Type, x.Name = "ident", n.ident
+	case *uuidNode:
+		x.Type, x.Name = "uuid", n.ident
+	case *literalNode:
+		x.Type, x.Value = "literal", n.value
+		switch n.value.(type) {
+		case nil:
+			x.Kind = "nil"
+		case bool:
+			x.Kind = "bool"
+		case float64:
+			x.Kind = "number"
+		case string:
+			x.Kind = "string"
+		default:
+			return nil, fmt.Errorf("Cannot encode literal of type %T", n.value)
+		}
+	default:
+		return nil, fmt.Errorf("Cannot encode node of type %T", e)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+/**
+ * Encode a pair of nodes
+ */
+func encodePair(left, right executable) (*encodedNode, *encodedNode, error) {
+	l, err := encodeNode(left)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := encodeNode(right)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, r, nil
+}
+
+/**
+ * The receiver of a method invocation. The parser shares the left operand
+ * of a dereference with the invocation that follows it, which encodes the
+ * operand twice; when decoded, the copies must match and are shared again.
+ */
+type receiver struct {
+	x *encodedNode
+	e executable
+}
+
+/**
+ * Decode an expression tree node. The resulting tree is validated to have
+ * the same structure the parser would produce. The receiver is the left
+ * operand of the enclosing dereference, if the node is its first element.
+ */
+func decodeNode(pos *positioner, x *encodedNode, recv *receiver, depth int) (executable, error) {
+	if x == nil {
+		return nil, fmt.Errorf("Invalid encoding: missing node")
+	}
+	if depth > encodingDepth {
+		return nil, fmt.Errorf("Invalid encoding: tree is too deep")
+	}
+	if text := pos.text; x.Span[0] < 0 || x.Span[1] < 0 || x.Span[0] > len(text) || x.Span[1] > len(text)-x.Span[0] {
+		return nil, fmt.Errorf("Invalid encoding: span out of range: %v", x.Span)
+	}
+
+	var err error
+	var left, right executable
+	n := node{pos.span(x.Span[0], x.Span[1]), nil}
+
+	switch x.Type {
+	case "or", "and", "relational", "arithmetic", "index":
+		left, err = decodeNode(pos, x.Left, nil, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		right, err = decodeNode(pos, x.Right, nil, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	case "deref":
+		left, err = decodeNode(pos, x.Left, recv, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		right, err = decodeNode(pos, x.Right, &receiver{x.Left, left}, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch x.Type {
+	case "or":
+		return &logicalOrNode{n, left, right}, nil
+	case "and":
+		return &logicalAndNode{n, left, right}, nil
+	case "relational":
+		op, err := decodeOperator(n.span, x.Op, tokenEqual, tokenNotEqual, tokenLess, tokenLessEqual, tokenGreater, tokenGreaterEqual, tokenIn)
+		if err != nil {
+			return nil, err
+		}
+		return &relationalNode{n, op, left, right}, nil
+	case "arithmetic":
+		op, err := decodeOperator(n.span, x.Op, tokenAdd, tokenSub, tokenMul, tokenDiv, tokenMod)
+		if err != nil {
+			return nil, err
+		}
+		return &arithmeticNode{n, op, left, right}, nil
+	case "deref":
+		switch right.(type) {
+		case *identNode, *derefNode, *indexNode, *invokeNode:
+			markMember(right)
+			return &derefNode{n, left, right}, nil
+		default:
+			return nil, fmt.Errorf("Invalid encoding: expected ident, deref or subscript: %T", right)
+		}
+	case "index":
+		return &indexNode{n, left, right}, nil
+	case "invoke":
+		if (x.Left == nil) != (recv == nil) || (recv != nil && !reflect.DeepEqual(x.Left, recv.x)) {
+			return nil, fmt.Errorf("Invalid encoding: invocation receiver does not match its dereference")
+		}
+		if recv != nil {
+			left = recv.e
+		}
+		right, err = decodeNode(pos, x.Right, nil, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		params := make([]executable, len(x.Args))
+		for i, a := range x.Args {
+			params[i], err = decodeNode(pos, a, nil, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &invokeNode{n, left, right, params}, nil
+	case "ident":
+		if x.Name == "" {
+			return nil, fmt.Errorf("Invalid encoding: identifier has no name")
+		}
+		return &identNode{n, x.Name, false}, nil
+	case "uuid":
+		u, err := uuidFromIdent(x.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encoding: %v", err)
+		}
+		return &uuidNode{identNode{n, x.Name, false}, u}, nil
+	case "literal":
+		v, err := decodeLiteral(x.Kind, x.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &literalNode{n, v}, nil
+	default:
+		return nil, fmt.Errorf("Invalid encoding: unsupported node type: %q", x.Type)
+	}
+}
+
+/**
+ * Checks a decoded tree against the compile limits it was encoded with.
+ * Depth and nodes are accounted as the parser accounts for them, assuming
+ * the fewest parentheses the tree requires, so that a tree produced by
+ * the parser under the limits is never rejected.
+ */
+type limiter struct {
+	opts       CompileOptions
+	max, nodes int
+}
+
+/**
+ * Check a node which is parsed at the provided depth
+ */
+func (l *limiter) check(e executable, depth int) error {
+	if err := l.node(e, depth); err != nil {
+		return err
+	}
+
+	switch n := e.(type) {
+	case *logicalOrNode:
+		return l.operands(precedence(n), n.left, n.right, depth)
+	case *logicalAndNode:
+		return l.operands(precedence(n), n.left, n.right, depth)
+	case *relationalNode:
+		return l.operands(precedence(n), n.left, n.right, depth)
+	case *arithmeticNode:
+		return l.operands(precedence(n), n.left, n.right, depth)
+	case *derefNode:
+		if err := l.operand(n.left, depth, precedence(n.left) > precedence(n)); err != nil {
+			return err
+		}
+		return l.operand(n.right, depth+1, precedence(n.right) >= precedence(n))
+	case *invokeNode:
+		// the receiver is the left operand of the dereference, which is already accounted for
+		if err := l.operand(n.right, depth, precedence(n.right) > precedence(n)); err != nil {
+			return err
+		}
+		for _, a := range n.params {
+			if err := l.check(a, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *indexNode:
+		// each subscript of a chain is one level deeper than the one before it
+		chain := []*indexNode{n}
+		for v, ok := n.left.(*indexNode); ok; v, ok = v.left.(*indexNode) {
+			if err := l.node(v, depth); err != nil {
+				return err
+			}
+			chain = append(chain, v)
+		}
+		if err := l.enter(n, depth+len(chain)); err != nil {
+			return err
+		}
+		base := chain[len(chain)-1].left
+		if err := l.operand(base, depth, precedence(base) > precedence(n)); err != nil {
+			return err
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			if err := l.check(chain[i].right, depth+len(chain)-i); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *identNode:
+		if _, err := uuidFromIdent(n.ident); err == nil {
+			return nil // a UUID identifier, which is not limited
+		}
+		if max := l.opts.MaxIdentifierLength; max > 0 && len(n.ident) > max {
+			return errorf(KindCompile, n.src(), "Identifier is too long: %d bytes (maximum: %d)", len(n.ident), max)
+		}
+	}
+	return nil
+}
+
+/**
+ * Account for a level of nesting, described by the provided node
+ */
+func (l *limiter) enter(e executable, depth int) error {
+	if depth > l.max {
+		return errorf(KindCompile, e.src(), "Expression is nested too deeply (maximum depth: %d)", l.max)
+	}
+	return nil
+}
+
+/**
+ * Account for a node at the provided depth
+ */
+func (l *limiter) node(e executable, depth int) error {
+	if err := l.enter(e, depth); err != nil {
+		return err
+	}
+	l.nodes++
+	if max := l.opts.MaxNodes; max > 0 && l.nodes > max {
+		return errorf(KindCompile, e.src(), "Expression has too many nodes (maximum: %d)", max)
+	}
+	return nil
+}
+
+/**
+ * Check the operands of a binary operator. The parser associates operators
+ * to the right, so an operand of lower precedence on the right, or of the
+ * same precedence on the left, must be parenthesized.
+ */
+func (l *limiter) operands(p int, left, right executable, depth int) error {
+	if err := l.operand(left, depth, precedence(left) > p); err != nil {
+		return err
+	}
+	return l.operand(right, depth+1, precedence(right) >= p)
+}
+
+/**
+ * Check an operand, which is one level deeper if it must be parenthesized
+ */
+func (l *limiter) operand(e executable, depth int, bare bool) error {
+	if !bare {
+		depth++
+	}
+	return l.check(e, depth)
+}
+
+/**
+ * The precedence of the rule which parses a node
+ */
+func precedence(e executable) int {
+	switch n := e.(type) {
+	case *logicalOrNode:
+		return 1
+	case *logicalAndNode:
+		return 2
+	case *relationalNode:
+		return 3
+	case *arithmeticNode:
+		if n.op.which == tokenAdd || n.op.which == tokenSub {
+			return 4
+		}
+		return 5
+	case *derefNode:
+		return 6
+	case *invokeNode:
+		return 7
+	case *indexNode:
+		return 8
+	default:
+		return 9
+	}
+}
+
+/**
+ * Decode an operator
+ */
+func decodeOperator(s span, op string, valid ...tokenType) (token, error) {
+	for _, e := range valid {
+		if encodedOperators[e] == op {
+			return token{s, e, nil}, nil
+		}
+	}
+	return token{}, fmt.Errorf("Invalid encoding: unsupported operator: %q", op)
+}
+
+/**
+ * Decode a literal value, checking it against its declared kind
+ */
+func decodeLiteral(kind string, v interface{}) (interface{}, error) {
+	var ok bool
+	switch kind {
+	case "nil":
+		ok = v == nil
+	case "bool":
+		if v == nil {
+			v, ok = false, true // omitted as empty
+		} else {
+			_, ok = v.(bool)
+		}
+	case "number":
+		if v == nil {
+			v, ok = float64(0), true // omitted as empty
+		} else {
+			_, ok = v.(float64)
+		}
+	case "string":
+		if v == nil {
+			v, ok = "", true // omitted as empty
+		} else {
+			_, ok = v.(string)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("Invalid encoding: invalid literal: %v (%v)", v, kind)
+	}
+	return v, nil
+}
+
+/**
+ * Write an encoded node. The node is expected to have been produced by
+ * encodeNode and is therefore valid.
+ */
+func (w *binaryWriter) node(x *encodedNode) {
+	w.buf.WriteByte(byte(indexOf(encodedNodeTypes, x.Type)))
+	w.int(int64(x.Span[0]))
+	w.int(int64(x.Span[1]))
+
+	switch x.Type {
+	case "or", "and", "deref", "index":
+		w.node(x.Left)
+		w.node(x.Right)
+	case "relational", "arithmetic":
+		w.string(x.Op)
+		w.node(x.Left)
+		w.node(x.Right)
+	case "invoke":
+		if x.Left != nil {
+			w.buf.WriteByte(1)
+			w.node(x.Left)
+		} else {
+			w.buf.WriteByte(0)
+		}
+		w.node(x.Right)
+		w.uint(uint64(len(x.Args)))
+		for _, a := range x.Args {
+			w.node(a)
+		}
+	case "ident", "uuid":
+		w.string(x.Name)
+	case "literal":
+		w.buf.WriteByte(byte(indexOf(encodedLiteralKinds, x.Kind)))
+		switch v := x.Value.(type) {
+		case bool:
+			if v {
+				w.buf.WriteByte(1)
+			} else {
+				w.buf.WriteByte(0)
+			}
+		case float64:
+			w.uint(math.Float64bits(v))
+		case string:
+			w.string(v)
+		}
+	}
+}
+
+/**
+ * Read an encoded node
+ */
+func (r *binaryReader) node(depth int) *encodedNode {
+	if r.err != nil {
+		return nil
+	}
+	if depth > encodingDepth {
+		r.fail("tree is too deep")
+		return nil
+	}
+
+	t := int(r.byte())
+	if r.err != nil {
+		return nil
+	}
+	if t >= len(encodedNodeTypes) {
+		r.fail("unsupported node type")
+		return nil
+	}
+
+	x := &encodedNode{Type: encodedNodeTypes[t]}
+	x.Span = [2]int{int(r.int()), int(r.int())}
+
+	switch x.Type {
+	case "or", "and", "deref", "index":
+		x.Left = r.node(depth + 1)
+		x.Right = r.node(depth + 1)
+	case "relational", "arithmetic":
+		x.Op = r.string()
+		x.Left = r.node(depth + 1)
+		x.Right = r.node(depth + 1)
+	case "invoke":
+		if r.byte() != 0 {
+			x.Left = r.node(depth + 1)
+		}
+		x.Right = r.node(depth + 1)
+		x.Args = make([]*encodedNode, r.count())
+		for i := range x.Args {
+			x.Args[i] = r.node(depth + 1)
+		}
+	case "ident", "uuid":
+		x.Name = r.string()
+	case "literal":
+		k := int(r.byte())
+		if k >= len(encodedLiteralKinds) {
+			r.fail("unsupported literal kind")
+			return nil
+		}
+		x.Kind = encodedLiteralKinds[k]
+		switch x.Kind {
+		case "bool":
+			x.Value = r.byte() != 0
+		case "number":
+			x.Value = math.Float64frombits(r.uint())
+		case "string":
+			x.Value = r.string()
+		}
+	}
+
+	return x
+}
+
+/**
+ * Find the index of a string in a list
+ */
+func indexOf(l []string, v string) int {
+	for i, e := range l {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+ * A writer for compact binary encodings
+ */
+type binaryWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *binaryWriter) uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *binaryWriter) int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (w *binaryWriter) string(v string) {
+	w.uint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+// Standard library options are written as flags followed by the allow list, if any
+const (
+	stdlibPresent byte = 1 << iota
+	stdlibSandboxed
+	stdlibAllow
+)
+
+func (w *binaryWriter) stdlib(v *encodedStdlib) {
+	if v == nil {
+		w.buf.WriteByte(0)
+		return
+	}
+	f := stdlibPresent
+	if v.Sandboxed {
+		f |= stdlibSandboxed
+	}
+	if v.Allow != nil {
+		f |= stdlibAllow
+	}
+	w.buf.WriteByte(f)
+	if v.Allow != nil {
+		w.uint(uint64(len(v.Allow)))
+		for _, e := range v.Allow {
+			w.string(e)
+		}
+	}
+}
+
+/**
+ * A reader for compact binary encodings. The first error encountered is
+ * retained and all subsequent reads produce zero values.
+ */
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) fail(m string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("Invalid encoding: %s", m)
+	}
+}
+
+func (r *binaryReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	v, err := r.r.ReadByte()
+	if err != nil {
+		r.fail("unexpected end of input")
+	}
+	return v
+}
+
+func (r *binaryReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.fail("unexpected end of input")
+	}
+	return v
+}
+
+func (r *binaryReader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	if err != nil {
+		r.fail("unexpected end of input")
+	}
+	return v
+}
+
+// Read a count, which cannot exceed the amount of remaining input
+func (r *binaryReader) count() int {
+	v := r.uint()
+	if v > uint64(r.r.Len()) {
+		r.fail("count out of range")
+		return 0
+	}
+	return int(v)
+}
+
+// Compile limits are written in order, as zero when there are none
+func (w *binaryWriter) limits(v *encodedLimits) {
+	if v == nil {
+		v = &encodedLimits{}
+	}
+	for _, e := range []int{v.MaxSourceLength, v.MaxDepth, v.MaxNodes, v.MaxIdentifierLength} {
+		w.int(int64(e))
+	}
+}
+
+func (r *binaryReader) limits() *encodedLimits {
+	v := &encodedLimits{}
+	for _, e := range []*int{&v.MaxSourceLength, &v.MaxDepth, &v.MaxNodes, &v.MaxIdentifierLength} {
+		*e = int(r.int())
+	}
+	if *v == (encodedLimits{}) {
+		return nil
+	}
+	return v
+}
+
+// Names are written as a count followed by each name
+func (w *binaryWriter) names(v []string) {
+	w.uint(uint64(len(v)))
+	for _, e := range v {
+		w.string(e)
+	}
+}
+
+func (r *binaryReader) names() []string {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	v := make([]string, n)
+	for i := range v {
+		v[i] = r.string()
+		if r.err == nil && !isIdentifier(v[i]) {
+			r.fail("invalid name")
+		}
+	}
+	return v
+}
+
+func (r *binaryReader) stdlib() *encodedStdlib {
+	f := r.byte()
+	if f == 0 {
+		return nil
+	} else if f&^(stdlibPresent|stdlibSandboxed|stdlibAllow) != 0 || f&stdlibPresent == 0 {
+		r.fail("invalid stdlib options")
+		return nil
+	}
+	v := &encodedStdlib{Sandboxed: f&stdlibSandboxed != 0}
+	if f&stdlibAllow != 0 {
+		v.Allow = make([]string, r.count())
+		for i := range v.Allow {
+			v.Allow[i] = r.string()
+		}
+	}
+	return v
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.fail("unexpected end of input")
+	}
+	return string(b)
+}
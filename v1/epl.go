@@ -30,9 +30,75 @@
 
 package epl
 
+import (
+  "reflect"
+)
+
+/**
+ * An execution engine
+ */
+type Engine int
+
+const (
+  EngineCompiled    = Engine(iota)  // the expression tree is compiled to closures (the default)
+  EngineInterpreted                 // the expression tree is executed directly
+  EngineBytecode                    // the expression tree is compiled to bytecode and run on a stack machine
+)
+
+/**
+ * Engine name
+ */
+func (e Engine) String() string {
+  switch e {
+    case EngineCompiled:
+      return "compiled"
+    case EngineInterpreted:
+      return "interpreted"
+    case EngineBytecode:
+      return "bytecode"
+    default:
+      return "unknown"
+  }
+}
+
+/**
+ * The maximum depth of an expression when CompileOptions.MaxDepth is zero.
+ * Deeper expressions could exhaust the stack when they are parsed and
+ * compiled, so some limit is always enforced.
+ */
+const DefaultMaxDepth = 1000
+
+/**
+ * Compile options. A zero value for a limit other than MaxDepth means that
+ * it is not enforced.
+ */
+type CompileOptions struct {
+  Engine              Engine
+  MaxSourceLength     int             // the maximum length of the source, in bytes
+  MaxDepth            int             // the maximum nesting depth of the expression tree (see DefaultMaxDepth)
+  MaxNodes            int             // the maximum number of nodes in the expression tree
+  MaxIdentifierLength int             // the maximum length of an identifier, in bytes
+  Stdlib              *StdlibOptions  // the builtins available to the program; nil for all of them
+  Libraries           []*Library      // libraries of functions available to the program, in addition to the builtins
+  Schema              Schema          // the types of the variables the program will be executed with, if known
+  Result              reflect.Type    // the type the result must be convertible to, if any (see Eval)
+}
+
 /**
  * Compile a program
  */
 func Compile(source string) (*Program, error) {
-  return newParser(newScanner(source)).parse()
+  return CompileWithOptions(source, CompileOptions{})
+}
+
+/**
+ * Compile a program with options
+ */
+func CompileWithOptions(source string, opts CompileOptions) (*Program, error) {
+  if opts.MaxSourceLength > 0 && len(source) > opts.MaxSourceLength {
+    return nil, errorf(KindCompile, span{}, "Source is too long: %d bytes (maximum: %d)", len(source), opts.MaxSourceLength)
+  }
+  p := newParser(newScanner(source))
+  p.opts = opts
+  return p.parse()
 }
@@ -31,8 +31,21 @@
 package epl
 
 import (
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -83,8 +96,8 @@ func TestBasicTypes(t *testing.T) {
 
 	source = `123`
 	compileAndValidate(t, source, []token{
-		token{span{source, 0, 3}, tokenNumber, float64(123)},
-		token{span{source, 6, 0}, tokenEOF, nil},
+		token{newSpan(source, 0, 3), tokenNumber, float64(123)},
+		token{newSpan(source, 6, 0), tokenEOF, nil},
 	})
 
 }
@@ -231,6 +244,16 @@ func TestParse(t *testing.T) {
 	parseAndRun(t, `"yes" != "yes"`, nil, false)
 	parseAndRun(t, `"yes" != 1`, nil, true)
 
+	// membership
+	parseAndRun(t, `"Two" in arr`, nil, true)
+	parseAndRun(t, `"Four" in arr`, nil, false)
+	parseAndRun(t, `"bat" in foo`, nil, true)
+	parseAndRun(t, `"baz" in foo`, nil, false)
+	parseAndRun(t, `"val" in foo.bat`, nil, true)
+	parseAndRun(t, `1 in foo.bat`, nil, testRuntimeError)
+	parseAndRun(t, `"Two" in num`, nil, testRuntimeError)
+	parseAndRun(t, `(1 + 1 in arr) == false`, nil, true)
+
 	// arithmetic
 	parseAndRun(t, `1 + 2`, nil, float64(3))
 	parseAndRun(t, `1.5 + 2.5`, nil, float64(4))
@@ -239,6 +262,11 @@ func TestParse(t *testing.T) {
 	parseAndRun(t, `10 / 2`, nil, float64(5))
 	parseAndRun(t, `10 * 2`, nil, float64(20))
 	parseAndRun(t, `10 % 3`, nil, int64(1))
+	parseAndRun(t, `10 % 0`, nil, testRuntimeError)
+	parseAndRun(t, `-7 % 3`, nil, int64(-1))
+	parseAndRun(t, `7.9 % -3`, nil, int64(1))
+	parseAndRun(t, `10 % 0.5`, nil, testRuntimeError)
+	parseAndRun(t, `1e20 % 7`, nil, testRuntimeError)
 
 	// string concatenation
 	parseAndRun(t, `"A" + "B"`, nil, "AB")
@@ -332,6 +360,11 @@ func TestParse(t *testing.T) {
 		return n, nil
 	}}, "7388AA2B-44C3-4146-8F17-C78F89B5F7D8")
 
+	// UUID values, for UUID identifiers which are not defined
+	parseAndRun(t, `u:7388AA2B-44C3-4146-8F17-C78F89B5F7D8`, nil, UUID{0x73, 0x88, 0xaa, 0x2b, 0x44, 0xc3, 0x41, 0x46, 0x8f, 0x17, 0xc7, 0x8f, 0x89, 0xb5, 0xf7, 0xd8})
+	parseAndRun(t, `u:7388AA2B-44C3-4146-8F17-C78F89B5F7D8 == "7388aa2b-44c3-4146-8f17-c78f89b5f7d8"`, nil, true)
+	parseAndRun(t, `uuidVersion(u:7388AA2B-44C3-4146-8F17-C78F89B5F7D8)`, nil, 4)
+
 	// standard library
 	parseAndRun(t, `len("hello")`, nil, 5)
 	parseAndRun(t, `len(arr)`, nil, 4)
@@ -353,6 +386,1928 @@ func TestParse(t *testing.T) {
 
 }
 
+func TestOptimize(t *testing.T) {
+	literal := func(v interface{}) func(executable) bool {
+		return func(e executable) bool {
+			l, ok := e.(*literalNode)
+			return ok && reflect.DeepEqual(l.value, v)
+		}
+	}
+	is := func(x interface{}) func(executable) bool {
+		return func(e executable) bool {
+			return reflect.TypeOf(e) == reflect.TypeOf(x)
+		}
+	}
+
+	tests := []struct {
+		source string
+		check  func(executable) bool
+	}{
+		{`60 * 60 * 24`, literal(float64(86400))},
+		{`"prefix" + "suffix"`, literal("prefixsuffix")},
+		{`(1 + 2) * 3 == 9`, literal(true)},
+		{`"hello"[1]`, literal("e")},
+		{`true && num`, is(&truthNode{})},
+		{`num && true`, is(&truthNode{})},
+		{`false || num`, is(&truthNode{})},
+		{`false && num`, literal(false)},
+		{`true || num`, literal(true)},
+		{`true && 1 < 2`, literal(true)},
+		{`num || true`, is(&logicalOrNode{})},
+		{`"A" + 100`, is(&arithmeticNode{})},
+		{`match("^a+$", "aaa")`, is(&matchNode{})},
+		{`match(foo.bat, "aaa")`, is(&invokeNode{})},
+		{`num + 1 * 2`, is(&arithmeticNode{})},
+	}
+	for _, e := range tests {
+		p, err := Compile(e.source)
+		if err != nil {
+			t.Errorf("[%s] %v", e.source, err)
+			continue
+		}
+		if !e.check(p.root) {
+			b := &strings.Builder{}
+			p.Print(b, PrintOptionOptimized)
+			t.Errorf("[%s] Unexpected optimized tree:\n%s", e.source, b.String())
+		}
+	}
+
+	// constant regular expressions still work through the optimized node
+	parseAndRun(t, `match("^a+$", "aaa")`, nil, true)
+	parseAndRun(t, `match("^a+$", "bbb")`, nil, false)
+	parseAndRun(t, `match("^a+$", num)`, nil, testRuntimeError)
+	parseAndRun(t, `match("(", "aaa")`, nil, testCompileError)
+	parseAndRun(t, `match(foo.bat + "(", "aaa")`, nil, testRuntimeError)
+
+	// pruned logical operators still coerce the remaining operand
+	parseAndRun(t, `true && num`, nil, true)
+	parseAndRun(t, `false || 0`, nil, false)
+	parseAndRun(t, `true && "yes"`, nil, testRuntimeError)
+	parseAndRun(t, `false && "yes"`, nil, false)
+
+	// match() shadowed by the context is not assumed to be the builtin
+	parseAndRun(t, `match("^a+$", "bbb")`, map[string]interface{}{
+		"match": func(a, b string) string { return a + b },
+	}, "^a+$bbb")
+	// but a literal pattern is checked when the program is compiled regardless
+	parseAndRun(t, `match("(", "bbb")`, map[string]interface{}{
+		"match": func(a, b string) string { return a + b },
+	}, testCompileError)
+}
+
+func TestEngines(t *testing.T) {
+	cxt := &SomeContext{StringField: "Hello", IntField: 3, SliceField: []string{"a", "b"}}
+	source := `StringFieldMethod + ", " + SliceField[1] == "Hello, b" && (IntField * 2 > 5 || missing) && match("^H", StringField)`
+	for _, e := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+		p, err := CompileWithOptions(source, CompileOptions{Engine: e})
+		if err != nil {
+			t.Errorf("[%v] %v", e, err)
+			continue
+		}
+		res, err := p.Exec(cxt)
+		if err != nil {
+			t.Errorf("[%v] %v", e, err)
+		} else if res != true {
+			t.Errorf("[%v] Expected <true>, got <%v>", e, res)
+		}
+	}
+	if _, err := CompileWithOptions(source, CompileOptions{Engine: Engine(99)}); err == nil {
+		t.Errorf("Expected an error for an unsupported engine")
+	}
+}
+
+func TestBytecode(t *testing.T) {
+	cxt := &SomeContext{StringField: "Hello", IntField: 3}
+	p, err := Compile(`printf("%v", IntField % 2) && (StringField == "Hello" || false) && len(StringField) + 10 % 3 == 6`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.Bytecode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := LoadBytecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := x.Exec(cxt); err != nil {
+		t.Error(err)
+	} else if res != true {
+		t.Errorf("Expected <true>, got <%v>", res)
+	}
+	if err := x.Print(&strings.Builder{}, 0); err == nil {
+		t.Errorf("Expected an error printing a program loaded from bytecode")
+	}
+
+	// re-encoding a loaded program produces the same bytecode
+	if v, err := x.Bytecode(); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(v, data) {
+		t.Errorf("Re-encoded bytecode differs")
+	}
+
+	// a remainder too large for an int64 is folded into a uint64 constant
+	p, err = CompileWithOptions(`1.7e19 % 1.8e19`, CompileOptions{Engine: EngineBytecode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p.Bytecode(); err != nil {
+		t.Error(err)
+	} else if x, err := LoadBytecode(v); err != nil {
+		t.Error(err)
+	} else if res, err := x.Exec(nil); err != nil {
+		t.Error(err)
+	} else if res != uint64(1.7e19) {
+		t.Errorf("Expected <%v>, got <%#v>", uint64(1.7e19), res)
+	}
+
+	// malformed bytecode must be rejected, never panic
+	for i := 0; i < len(data); i++ {
+		if _, err := LoadBytecode(data[:i]); err == nil {
+			t.Errorf("Expected an error loading truncated bytecode (%d bytes)", i)
+		}
+	}
+	for i := len(bytecodeMagic); i < len(data); i++ {
+		for _, v := range []byte{0, 1, 0x7f, 0xff} {
+			mod := append([]byte(nil), data...)
+			mod[i] = v
+			x, err := LoadBytecode(mod)
+			if err == nil {
+				x.Exec(cxt) // may fail, but must not panic
+			}
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	p, err := CompileWithOptions(`a.b == 1 && f(-2, "x", true, nil)`, CompileOptions{Engine: EngineBytecode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cxt := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1},
+		"f": func(a float64, b string, c bool, d interface{}) bool { return a == -2 && b == "x" && c && d == nil },
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(string(data))
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["version"] != float64(encodingVersion) || raw["engine"] != "bytecode" || raw["source"] != `a.b == 1 && f(-2, "x", true, nil)` {
+		t.Errorf("Unexpected JSON header: %v", raw)
+	}
+	if root, ok := raw["root"].(map[string]interface{}); !ok || root["type"] != "and" {
+		t.Errorf("Unexpected JSON root: %v", raw["root"])
+	}
+
+	x := &Program{}
+	if err := json.Unmarshal(data, x); err != nil {
+		t.Fatal(err)
+	}
+	if x.opts.Engine != EngineBytecode {
+		t.Errorf("Expected the engine to be preserved, got: %v", x.opts.Engine)
+	}
+	if res, err := x.Exec(cxt); err != nil {
+		t.Error(err)
+	} else if res != true {
+		t.Errorf("Expected <true>, got <%v>", res)
+	}
+	if x.String() != p.String() {
+		t.Errorf("Decoded tree differs:\n%v\n%v", x.String(), p.String())
+	}
+
+	bin, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := &Program{}
+	if err := y.UnmarshalBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	if y.String() != p.String() {
+		t.Errorf("Decoded tree differs:\n%v\n%v", y.String(), p.String())
+	}
+
+	// malformed input must be rejected, never panic
+	for i := 0; i < len(bin); i++ {
+		if err := (&Program{}).UnmarshalBinary(bin[:i]); err == nil {
+			t.Errorf("Expected an error decoding truncated input (%d bytes)", i)
+		}
+	}
+	for i := len(encodingMagic); i < len(bin); i++ {
+		for _, v := range []byte{0, 1, 0x7f, 0xff} {
+			mod := append([]byte(nil), bin...)
+			mod[i] = v
+			z := &Program{}
+			if err := z.UnmarshalBinary(mod); err == nil {
+				z.Exec(cxt) // may fail, but must not panic
+			}
+		}
+	}
+	for _, e := range []string{
+		`{"version":1,"engine":"compiled","source":"a","root":{"type":"ident","name":"a","span":[0,1]}}`,
+		`{"version":2,"engine":"other","source":"a","root":{"type":"ident","name":"a","span":[0,1]}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"ident","name":"a","span":[0,2]}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"ident","name":"","span":[0,1]}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"other","span":[0,1]}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"literal","kind":"number","value":"1","span":[0,1]}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"relational","op":"+","span":[0,1],"left":{"type":"ident","name":"a","span":[0,1]},"right":{"type":"ident","name":"a","span":[0,1]}}}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"and","span":[0,1],"left":{"type":"ident","name":"a","span":[0,1]}}}`,
+		`{"version":2,"engine":"compiled","source":"a"}`,
+		`{"version":2,"engine":"compiled","source":"a","root":{"type":"invoke","span":[0,1],"left":{"type":"ident","name":"a","span":[0,1]},"right":{"type":"ident","name":"a","span":[0,1]}}}`,
+		`{"version":2,"engine":"compiled","stdlib":{"sandboxed":false,"allow":["nonexistent"]},"source":"a","root":{"type":"ident","name":"a","span":[0,1]}}`,
+	} {
+		if err := json.Unmarshal([]byte(e), &Program{}); err == nil {
+			t.Errorf("Expected an error decoding: %s", e)
+		}
+	}
+
+	// compile limits are preserved
+	opts := CompileOptions{Engine: EngineInterpreted, MaxSourceLength: 100, MaxDepth: 10, MaxNodes: 20, MaxIdentifierLength: 5}
+	p, err = CompileWithOptions(`a.b == 1`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = json.Marshal(p); err != nil {
+		t.Fatal(err)
+	}
+	x = &Program{}
+	if err := json.Unmarshal(data, x); err != nil {
+		t.Fatal(err)
+	}
+	if bin, err = p.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	y = &Program{}
+	if err := y.UnmarshalBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	code, err := p.Bytecode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := LoadBytecode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range []*Program{x, y, z} {
+		if o := d.opts; o.MaxSourceLength != 100 || o.MaxDepth != 10 || o.MaxNodes != 20 || o.MaxIdentifierLength != 5 {
+			t.Errorf("(%d) Expected compile limits to be preserved, got: %+v", i, o)
+		}
+	}
+}
+
+func TestEncodingLimits(t *testing.T) {
+	// programs compiled under limits which they just meet are decoded
+	for _, e := range []struct {
+		Source string
+		Opts   CompileOptions
+	}{
+		{`a.b(c) + d[1]`, CompileOptions{MaxDepth: 3, MaxNodes: 9}},
+		{`x.f(1).g(2)`, CompileOptions{MaxDepth: 4, MaxNodes: 9}},
+		{`a.b[1][x.y[2]].c(d, e[f])`, CompileOptions{MaxDepth: 6, MaxNodes: 18}},
+		{`(a*b+c)*d+e`, CompileOptions{MaxDepth: 3, MaxNodes: 9}},
+		{`((a))`, CompileOptions{MaxDepth: 3}},
+		{`abc + abcdef`, CompileOptions{MaxSourceLength: 12, MaxIdentifierLength: 6}},
+		{`x.U:01234567-89ab-cdef-0123-456789abcdef`, CompileOptions{MaxIdentifierLength: 1}},
+	} {
+		p, err := CompileWithOptions(e.Source, e.Opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &Program{}); err != nil {
+			t.Errorf("%s: %v", e.Source, err)
+		}
+		bin, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Program{}).UnmarshalBinary(bin); err != nil {
+			t.Errorf("%s: %v", e.Source, err)
+		}
+	}
+
+	// programs which claim limits they exceed are rejected
+	for _, e := range []struct {
+		Source string
+		Opts   CompileOptions
+		Error  string
+	}{
+		{`abc + abcdef`, CompileOptions{MaxSourceLength: 5}, "Source is too long: 12 bytes (maximum: 5)"},
+		{`abc + abcdef`, CompileOptions{MaxIdentifierLength: 5}, "Identifier is too long: 6 bytes (maximum: 5)"},
+		{`a.b(c) + d[1]`, CompileOptions{MaxNodes: 8}, "Expression has too many nodes (maximum: 8)"},
+		{`x.f(1).g(2)`, CompileOptions{MaxNodes: 8}, "Expression has too many nodes (maximum: 8)"},
+		{`a.b(c) + d[1]`, CompileOptions{MaxDepth: 2}, "Expression is nested too deeply (maximum depth: 2)"},
+		{`a[1][2][3]`, CompileOptions{MaxDepth: 3}, "Expression is nested too deeply (maximum depth: 3)"},
+		{`(a.b).c`, CompileOptions{MaxDepth: 2}, "Expression is nested too deeply (maximum depth: 2)"},
+	} {
+		p, err := Compile(e.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Opts.Engine = p.opts.Engine
+		p.opts = e.Opts
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range []error{json.Unmarshal(data, &Program{}), (&Program{}).UnmarshalBinary(bin)} {
+			var cerr *Error
+			if err == nil {
+				t.Errorf("%s: Expected an error, got none", e.Source)
+			} else if !errors.As(err, &cerr) || cerr.Kind != KindCompile || !strings.HasPrefix(err.Error(), e.Error) {
+				t.Errorf("%s: Expected <%v>, got <%v>", e.Source, e.Error, err)
+			}
+		}
+	}
+
+	// a tree deeper than the default is rejected when no depth is set
+	root := &encodedNode{Type: "ident", Name: "a", Span: [2]int{0, 1}}
+	for i := 0; i < DefaultMaxDepth; i++ {
+		root = &encodedNode{Type: "and", Span: [2]int{0, 1}, Left: &encodedNode{Type: "ident", Name: "a", Span: [2]int{0, 1}}, Right: root}
+	}
+	err := (&Program{}).decode(&encodedProgram{Version: encodingVersion, Engine: "compiled", Source: "a", Root: root})
+	if err == nil || !strings.HasPrefix(err.Error(), "Expression is nested too deeply (maximum depth: 1000)") {
+		t.Errorf("Expected a depth error, got: %v", err)
+	}
+}
+
+func TestEncodingLibraries(t *testing.T) {
+	lib := NewLibrary("test", "").MustRegister(Function{Name: "double", Func: func(n float64) float64 { return n * 2 }})
+	unused := NewLibrary("unused", "").MustRegister(Function{Name: "half", Func: func(n float64) float64 { return n / 2 }})
+	cxt := map[string]interface{}{
+		"a":      map[string]interface{}{"double": 1},
+		"double": func(n float64) float64 { return n },
+	}
+	for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+		p, err := CompileWithOptions(`double(2) + a.double`, CompileOptions{Engine: g, Libraries: []*Library{lib, unused}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := p.Exec(cxt); err != nil || v != float64(5) {
+			t.Fatalf("Expected <5>, got <%v> (%v)", v, err)
+		}
+
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"uses":["double"]`) {
+			t.Errorf("Expected the library functions used to be encoded: %s", data)
+		}
+		x := &Program{}
+		if err := json.Unmarshal(data, x); err != nil {
+			t.Fatal(err)
+		}
+		bin, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := &Program{}
+		if err := y.UnmarshalBinary(bin); err != nil {
+			t.Fatal(err)
+		}
+		code, err := p.Bytecode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := LoadBytecode(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, d := range []*Program{x, y, z} {
+			// without its library the program fails rather than invoking the variable of the same name
+			var cerr *Error
+			if v, err := d.Exec(cxt); err == nil {
+				t.Errorf("(%v, %d) Expected an error, got <%v>", g, i, v)
+			} else if !errors.As(err, &cerr) || cerr.Kind != KindUndefined || !strings.HasPrefix(cerr.Message, "No such library function 'double'") {
+				t.Errorf("(%v, %d) Expected a missing library error, got <%v>", g, i, err)
+			}
+			if v, err := d.ExecWithOptions(gocontext.Background(), cxt, ExecOptions{Libraries: []*Library{unused}}); err == nil {
+				t.Errorf("(%v, %d) Expected an error, got <%v>", g, i, v)
+			}
+			if v, err := d.ExecWithOptions(gocontext.Background(), cxt, ExecOptions{Libraries: []*Library{lib}}); err != nil || v != float64(5) {
+				t.Errorf("(%v, %d) Expected <5>, got <%v> (%v)", g, i, v, err)
+			}
+		}
+
+		// a decoded program encodes the functions it requires again
+		if data, err := json.Marshal(x); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(string(data), `"uses":["double"]`) {
+			t.Errorf("Expected the library functions used to be encoded again: %s", data)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"version":2,"engine":"compiled","uses":["not valid"],"source":"a","root":{"type":"ident","name":"a","span":[0,1]}}`), &Program{}); err == nil {
+		t.Errorf("Expected an error decoding an invalid function name")
+	}
+}
+
+type OtherContext struct {
+	Nested      *SomeContext
+	StringField int
+}
+
+func (c OtherContext) StringFieldMethod() string {
+	return "other"
+}
+
+func TestConcurrentExec(t *testing.T) {
+	sources := []string{
+		`StringField`,
+		`StringFieldMethod`,
+		`ReturnParamValueMethod(StringField)`,
+	}
+	contexts := []interface{}{
+		&SomeContext{StringField: "some"},
+		OtherContext{StringField: 123},
+		&OtherContext{StringField: 456},
+		map[string]interface{}{"StringField": "map", "StringFieldMethod": "map", "ReturnParamValueMethod": func(v interface{}) interface{} { return v }},
+	}
+
+	// expected results are produced serially by the interpreter, which uses
+	// only the shared type cache
+	expect := make([][]interface{}, len(sources))
+	programs := make([]*Program, len(sources))
+	for i, e := range sources {
+		p, err := CompileWithOptions(e, CompileOptions{Engine: EngineInterpreted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range contexts {
+			v, _ := p.Exec(c)
+			expect[i] = append(expect[i], v)
+		}
+		if programs[i], err = Compile(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same programs are shared by many goroutines, so member sites see a
+	// variety of types concurrently
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				i, j := (g+n)%len(sources), (g*n)%len(contexts)
+				v, _ := programs[i].Exec(contexts[j])
+				if !reflect.DeepEqual(v, expect[i][j]) {
+					t.Errorf("[%s] Expected <%v>, got <%v>", sources[i], expect[i][j], v)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestTypeCache(t *testing.T) {
+	cxt := map[string]interface{}{"x": &SomeContext{StringField: "some"}}
+	for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+		for _, e := range []string{`x.StringField`, `x.NotAMember`} {
+			p, err := CompileWithOptions(e, CompileOptions{Engine: g})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Exec(cxt)
+			p.Exec(cxt)
+		}
+	}
+	info := typeInfoOf(reflect.TypeOf(&SomeContext{}))
+	if _, ok := info.members.Load("StringField"); !ok {
+		t.Errorf("Expected a resolved member to be cached")
+	}
+	if _, ok := info.members.Load("NotAMember"); ok {
+		t.Errorf("Expected a name which is not a member not to be cached")
+	}
+}
+
+func TestExecContext(t *testing.T) {
+	vars := map[string]interface{}{
+		"wait": func(s *State) bool {
+			<-s.Runtime.Context.Done()
+			return true
+		},
+	}
+	for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+		p, err := CompileWithOptions(`wait() == true`, CompileOptions{Engine: g})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cxt, cancel := gocontext.WithCancel(gocontext.Background())
+		cancel()
+		_, err = p.ExecContext(cxt, vars)
+		if !errors.Is(err, gocontext.Canceled) {
+			t.Errorf("[%v] Expected cancellation, got <%v>", g, err)
+		}
+
+		// the host function observes the deadline through its state and
+		// evaluation stops at the next node
+		cxt, cancel = gocontext.WithTimeout(gocontext.Background(), time.Millisecond*10)
+		_, err = p.ExecContext(cxt, vars)
+		cancel()
+		if !errors.Is(err, gocontext.DeadlineExceeded) {
+			t.Errorf("[%v] Expected deadline exceeded, got <%v>", g, err)
+		}
+
+		// without cancellation the program runs as usual
+		v, err := p.ExecContext(gocontext.Background(), map[string]interface{}{"wait": func() bool { return true }})
+		if err != nil || v != true {
+			t.Errorf("[%v] Expected <true>, got <%v> (%v)", g, v, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	vars := map[string]interface{}{
+		"a":   1,
+		"s":   "Hello",
+		"f":   func(v interface{}) interface{} { return v },
+		"str": func() string { return "Hello, there" },
+		"arr": func() []int { return make([]int, 10) },
+	}
+	tests := []struct {
+		Source string
+		Opts   ExecOptions
+		Limit  Limit
+		Offset int
+		Length int
+	}{
+		{`a + a + a`, ExecOptions{MaxSteps: 3}, LimitSteps, -1, 0},
+		{`f(f(f(1)))`, ExecOptions{MaxCallDepth: 2}, LimitCallDepth, 4, 4},
+		{`f(1) + f(2) + f(3)`, ExecOptions{MaxHostCalls: 2}, LimitHostCalls, 14, 4},
+		{`s + s`, ExecOptions{MaxStringLength: 8}, LimitStringLength, 0, 5},
+		{`str() == "Hello"`, ExecOptions{MaxStringLength: 8}, LimitStringLength, 0, 5},
+		{`len(arr())`, ExecOptions{MaxCollectionSize: 5}, LimitCollectionSize, 4, 5},
+	}
+	for _, e := range tests {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			p, err := CompileWithOptions(e.Source, CompileOptions{Engine: g})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.ExecWithOptions(gocontext.Background(), vars, e.Opts)
+			var eerr *Error
+			var lerr *LimitError
+			if !errors.As(err, &eerr) || eerr.Kind != KindLimit || !errors.As(err, &lerr) {
+				t.Errorf("[%v] %s: Expected a limit error, got <%v>", g, e.Source, err)
+				continue
+			}
+			if lerr.Limit != e.Limit {
+				t.Errorf("[%v] %s: Expected limit <%v>, got <%v>", g, e.Source, e.Limit, lerr.Limit)
+			}
+			if e.Offset >= 0 && (eerr.Offset != e.Offset || eerr.Length != e.Length) {
+				t.Errorf("[%v] %s: Expected span <%d, %d>, got <%d, %d>", g, e.Source, e.Offset, e.Length, eerr.Offset, eerr.Length)
+			}
+			// the same program runs without limits
+			if _, err = p.Exec(vars); err != nil {
+				t.Errorf("[%v] %s: Expected no error, got <%v>", g, e.Source, err)
+			}
+		}
+	}
+}
+
+func TestStepParity(t *testing.T) {
+	vars := map[string]interface{}{
+		"a":   map[string]interface{}{"b": map[string]interface{}{"c": 1}},
+		"arr": []int{1, 2, 3},
+		"num": 2,
+		"ctx": &SomeContext{StringField: "Hello"},
+		"f":   func(v interface{}) interface{} { return v },
+	}
+	sources := []string{
+		`1 + 2 * num`,
+		`a.b.c == 1 && arr[num] > 2`,
+		`true && num`,
+		`false || a.b.c`,
+		`f(f(num) + 1) == 3 || missing`,
+		`ctx.StringFieldMethod + ctx.StringField`,
+		`match("^H", ctx.StringField) && len(arr) == 3`,
+		`u:3ea24a5a-c1ab-4fc3-b25f-1d1b8a45dc2e != nil`,
+	}
+	for _, e := range sources {
+		steps := make(map[Engine]int)
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			p, err := CompileWithOptions(e, CompileOptions{Engine: g})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// find the fewest steps the program can be executed in
+			for n := 1; n < 100; n++ {
+				_, err = p.ExecWithOptions(gocontext.Background(), vars, ExecOptions{MaxSteps: n})
+				var lerr *LimitError
+				if err == nil {
+					steps[g] = n
+					break
+				} else if !errors.As(err, &lerr) || lerr.Limit != LimitSteps {
+					t.Errorf("[%v] %s: Expected a step limit error, got <%v>", g, e, err)
+					break
+				}
+			}
+		}
+		if steps[EngineCompiled] < 1 || steps[EngineInterpreted] != steps[EngineCompiled] || steps[EngineBytecode] != steps[EngineCompiled] {
+			t.Errorf("%s: Expected every engine to take the same number of steps, got %v", e, steps)
+		}
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	tests := []struct {
+		Source string
+		Opts   CompileOptions
+		Error  string
+	}{
+		{`abc + abcdef`, CompileOptions{MaxSourceLength: 5}, "Source is too long: 12 bytes (maximum: 5)"},
+		{`abc + abcdef`, CompileOptions{MaxSourceLength: 12}, ""},
+		{`abc + abcdef`, CompileOptions{MaxIdentifierLength: 5}, "Identifier is too long: 6 bytes (maximum: 5)\n1: abc + abcdef\n         ^^^^^^\n"},
+		{`a.b(c) + d[1]`, CompileOptions{MaxNodes: 6}, "Expression has too many nodes (maximum: 6)\n1: a.b(c) + d[1]\n            ^\n"},
+		{`a.b(c) + d[1]`, CompileOptions{MaxNodes: 9}, ""},
+		{`((a))`, CompileOptions{MaxDepth: 2}, "Expression is nested too deeply (maximum depth: 2)\n1: ((a))\n     ^\n"},
+		{`((a))`, CompileOptions{MaxDepth: 3}, ""},
+		{strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000), CompileOptions{}, "Expression is nested too deeply (maximum depth: 1000)"},
+		{strings.Repeat("a + ", DefaultMaxDepth) + "a", CompileOptions{}, "Expression is nested too deeply (maximum depth: 1000)"},
+		{strings.Repeat("a + ", DefaultMaxDepth/2) + "a", CompileOptions{}, ""},
+	}
+	for _, e := range tests {
+		var cerr *Error
+		_, err := CompileWithOptions(e.Source, e.Opts)
+		if err == nil {
+			if e.Error != "" {
+				t.Errorf("%.32s: Expected an error, got none", e.Source)
+			}
+		} else if !errors.As(err, &cerr) || cerr.Kind != KindCompile {
+			t.Errorf("%.32s: Expected a compile error, got <%T>", e.Source, err)
+		} else if e.Error == "" || !strings.HasPrefix(err.Error(), e.Error) {
+			t.Errorf("%.32s: Expected <%v>, got <%.200v>", e.Source, e.Error, err)
+		}
+	}
+}
+
+func TestStdlib(t *testing.T) {
+	os.Setenv("EPL_TEST_SECRET", "secret")
+	defer os.Unsetenv("EPL_TEST_SECRET")
+
+	sandboxed := &StdlibOptions{Sandboxed: true}
+	tests := []struct {
+		Source  string
+		Compile *StdlibOptions
+		Exec    *StdlibOptions
+		Vars    map[string]interface{}
+		Result  interface{}
+	}{
+		{`env.EPL_TEST_SECRET`, nil, nil, nil, "secret"},
+		{`env.EPL_TEST_SECRET`, sandboxed, nil, nil, testRuntimeError},
+		{`env.EPL_TEST_SECRET`, nil, sandboxed, nil, testRuntimeError},
+		{`printf("Hello")`, sandboxed, nil, nil, testRuntimeError},
+		{`len("Hello")`, sandboxed, nil, nil, 5},
+		{`len("Hello")`, &StdlibOptions{Allow: []string{"match"}}, nil, nil, testRuntimeError},
+		{`match("^H", "Hello")`, &StdlibOptions{Allow: []string{"match"}}, nil, nil, true},
+		{`env.EPL_TEST_SECRET`, &StdlibOptions{Sandboxed: true, Allow: []string{"env"}}, nil, nil, testRuntimeError},
+		{`len("Hello")`, &StdlibOptions{Allow: []string{"nonexistent"}}, nil, nil, testCompileError},
+		{`len("Hello")`, nil, &StdlibOptions{Allow: []string{"nonexistent"}}, nil, testRuntimeError},
+		// a builtin which is not available may be provided by the context
+		{`printf("Hello")`, sandboxed, nil, map[string]interface{}{"printf": func(s string) string { return s }}, "Hello"},
+	}
+	for _, e := range tests {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			p, err := CompileWithOptions(e.Source, CompileOptions{Engine: g, Stdlib: e.Compile})
+			if err != nil {
+				if e.Result != testCompileError {
+					t.Errorf("[%v] %s: Could not compile: %v", g, e.Source, err)
+				}
+				continue
+			} else if e.Result == testCompileError {
+				t.Errorf("[%v] %s: Expected a compile error", g, e.Source)
+				continue
+			}
+			v, err := p.ExecWithOptions(gocontext.Background(), e.Vars, ExecOptions{Stdlib: e.Exec})
+			if err != nil {
+				if e.Result != testRuntimeError {
+					t.Errorf("[%v] %s: Could not execute: %v", g, e.Source, err)
+				}
+			} else if !reflect.DeepEqual(v, e.Result) {
+				t.Errorf("[%v] %s: Expected <%v>, got <%v>", g, e.Source, e.Result, v)
+			}
+		}
+	}
+}
+
+func TestStdlibEncoding(t *testing.T) {
+	os.Setenv("EPL_TEST_SECRET", "secret")
+	defer os.Unsetenv("EPL_TEST_SECRET")
+
+	tests := []struct {
+		Source string
+		Stdlib *StdlibOptions
+		Result interface{}
+	}{
+		{`env.EPL_TEST_SECRET`, nil, "secret"},
+		{`env.EPL_TEST_SECRET`, &StdlibOptions{Sandboxed: true}, testRuntimeError},
+		{`len("Hello")`, &StdlibOptions{Sandboxed: true}, 5},
+		{`len("Hello")`, &StdlibOptions{Allow: []string{"match"}}, testRuntimeError},
+		{`len("Hello")`, &StdlibOptions{Allow: []string{}}, testRuntimeError},
+		{`match("^H", "Hello")`, &StdlibOptions{Sandboxed: true, Allow: []string{"match", "printf"}}, true},
+	}
+	for _, e := range tests {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			p, err := CompileWithOptions(e.Source, CompileOptions{Engine: g, Stdlib: e.Stdlib})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := &Program{}
+			if err := json.Unmarshal(data, x); err != nil {
+				t.Fatal(err)
+			}
+			bin, err := p.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			y := &Program{}
+			if err := y.UnmarshalBinary(bin); err != nil {
+				t.Fatal(err)
+			}
+			code, err := p.Bytecode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			z, err := LoadBytecode(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the builtins available to a program are restored when it is decoded
+			for i, d := range []*Program{x, y, z} {
+				if !reflect.DeepEqual(d.opts.Stdlib, e.Stdlib) {
+					t.Errorf("[%v] %s (%d): Expected stdlib options %v, got %v", g, e.Source, i, e.Stdlib, d.opts.Stdlib)
+				}
+				v, err := d.Exec(map[string]interface{}{})
+				if err != nil {
+					if e.Result != testRuntimeError {
+						t.Errorf("[%v] %s (%d): Could not execute: %v", g, e.Source, i, err)
+					}
+				} else if !reflect.DeepEqual(v, e.Result) {
+					t.Errorf("[%v] %s (%d): Expected <%v>, got <%v>", g, e.Source, i, e.Result, v)
+				}
+			}
+		}
+	}
+
+	// a program which can't be restored as it was compiled is not loaded
+	p, err := CompileWithOptions(`len("Hello")`, CompileOptions{Engine: EngineBytecode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.code.opts.Stdlib = &StdlibOptions{Allow: []string{"nonexistent"}}
+	code, err := p.Bytecode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBytecode(code); err == nil {
+		t.Errorf("Expected an error loading bytecode which allows an unknown builtin")
+	}
+}
+
+func TestStdlibMembers(t *testing.T) {
+	lib := NewLibrary("greetings", "").MustRegister(Function{Name: "greet", Func: func(s string) string { return "Hello, " + s }})
+	vars := map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}},
+	}
+	// builtins and library functions are never resolved as members
+	for _, e := range []string{`a.index`, `a.b.title`, `a.title.x`, `a.index[0]`, `a.greet`, `a.index || a.b.c`} {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			p, err := CompileWithOptions(e, CompileOptions{Engine: g, Libraries: []*Library{lib}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := &Program{}
+			if err := json.Unmarshal(data, x); err != nil {
+				t.Fatal(err)
+			}
+			code, err := p.Bytecode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			y, err := LoadBytecode(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, z := range []*Program{p, x, y} {
+				v, err := z.ExecWithOptions(gocontext.Background(), vars, ExecOptions{Libraries: []*Library{lib}})
+				var eerr *Error
+				if !errors.As(err, &eerr) || eerr.Kind != KindUndefined {
+					t.Errorf("[%v] %s (%d): Expected an undefined variable error, got <%v> (%v)", g, e, i, v, err)
+				}
+			}
+		}
+	}
+	// but they are resolved for invocations and bare identifiers
+	for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+		p, err := CompileWithOptions(`a.b.c == index("abc", "b") && greet("you") == "Hello, you" && len(a) == 1`, CompileOptions{Engine: g, Libraries: []*Library{lib}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := p.Exec(vars); err != nil || v != true {
+			t.Errorf("[%v] Expected <true>, got <%v> (%v)", g, v, err)
+		}
+	}
+}
+
+func TestStringFunctions(t *testing.T) {
+	vars := map[string]interface{}{
+		"names": []interface{}{"ann", "bob"},
+		"mixed": []interface{}{"ann", 1},
+		"many":  strings.Repeat("a", 100000),
+		"long":  strings.Repeat("b", 100000),
+	}
+	tests := []execCase{
+		{`contains("chicken", "ken")`, true, ""},
+		{`contains("chicken", "duck")`, false, ""},
+		{`hasPrefix("chicken", "chi") && hasSuffix("chicken", "ken")`, true, ""},
+		{`lower("Hello, World") + upper("Hello, World")`, "hello, worldHELLO, WORLD", ""},
+		{`title("hello wORLD, it's o'neil")`, "Hello WORLD, It's O'neil", ""},
+		{`trim(" \t a b \n")`, "a b", ""},
+		{`trim("xxaxx", "x") + trimLeft("xxaxx", "x") + trimRight("xxaxx", "x")`, "aaxxxxa", ""},
+		{`trimLeft("  a  ") + trimRight("  a  ")`, "a    a", ""},
+		{`trimPrefix("chicken", "chi") + trimSuffix("chicken", "ken")`, "ckenchic", ""},
+		{`len(split("a,b,c", ","))`, 3, ""},
+		{`join(split("a,b,c", ","), "-")`, "a-b-c", ""},
+		{`join(fields("  a b   c "), "-")`, "a-b-c", ""},
+		{`join(names, ", ")`, "ann, bob", ""},
+		{`replace("banana", "a", "o")`, "bonono", ""},
+		{`replace("banana", "a", "o", 2)`, "bonona", ""},
+		{`replace("ab", "", "-")`, "-a-b-", ""},
+		{`len(replace(many, "a", long, 2))`, 299998, ""},
+		{`index("chicken", "ken")`, 4, ""},
+		{`index("chicken", "duck")`, -1, ""},
+		{`repeat("ab", 3)`, "ababab", ""},
+		{`repeat("ab", 0)`, "", ""},
+		{`equalFold("Straße", "STRASSE") || equalFold("Go", "GO")`, true, ""},
+		// invalid parameters
+		{`contains("chicken", 1)`, nil, "Invalid parameter to: contains(string, string) (parameter 2 is float64, expected string)"},
+		{`upper(nil)`, nil, "Invalid parameter to: upper(string) (parameter 1 is <nil>, expected string)"},
+		{`trim("a", "b", "c")`, nil, "Invalid number of parameters to: trim(string[, string]) (given 3)"},
+		{`join("a", ",")`, nil, "Invalid parameter to: join(list, string) (parameter 1 is string, expected list)"},
+		{`join(mixed, ",")`, nil, "Invalid parameter to: join(list, string) (element 1 of parameter 1 is int, expected string)"},
+		{`replace("a", "a", "b", 1.5)`, nil, "Invalid parameter to: replace(string, string, string[, int]) (parameter 4 is float64, expected int)"},
+		{`repeat("ab", -1)`, nil, "Invalid parameter to: repeat(string, int) (parameter 2 is -1, expected non-negative int)"},
+		{`repeat("ab", 4294967296)`, nil, "Invalid parameter to: repeat(string, int) (parameter 2 is float64, expected int)"},
+		{`replace(many, "a", long)`, nil, "Invalid parameter to: replace(string, string, string[, int]) (parameter 3 is string, expected shorter string)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+
+	// a string which would exceed the limit is not produced
+	for _, e := range []string{`repeat("abc", 1000)`, `replace(many, "a", "bc")`, `replace("abc", "", long)`} {
+		p, err := Compile(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.ExecWithOptions(gocontext.Background(), vars, ExecOptions{MaxStringLength: 1000})
+		var limit *LimitError
+		if !errors.As(err, &limit) || limit.Limit != LimitStringLength {
+			t.Errorf("%s: Expected a string length limit error, got <%v>", e, err)
+		}
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	vars := map[string]interface{}{
+		"scores": []int{7, 3, 9},
+		"zero":   0,
+	}
+	tests := []execCase{
+		{`abs(-2.5) + abs(2.5)`, float64(5), ""},
+		{`floor(2.7) + ceil(2.1) + floor(-2.5)`, float64(2), ""},
+		{`round(2.5) + round(-2.5)`, float64(0), ""},
+		{`round(3.14159, 2)`, 3.14, ""},
+		{`round(1234.5, -2)`, float64(1200), ""},
+		{`round(1.5, 400)`, 1.5, ""},
+		{`round(123, -400) + round(-1.7e308, -309)`, float64(0), ""},
+		{`round(1.2e308, -308)`, 1e308, ""},
+		{`min(3, 1, 2) + max(3, 1, 2)`, float64(4), ""},
+		{`min(scores) + max(scores)`, float64(12), ""},
+		{`max(5)`, float64(5), ""},
+		{`clamp(5, 0, 10) + clamp(-5, 0, 10) + clamp(15, 0, 10)`, float64(15), ""},
+		{`pow(2, 10) + sqrt(16)`, float64(1028), ""},
+		{`log(exp(2))`, float64(2), ""},
+		{`isNaN(sqrt(-1)) && isNaN(1) == false`, true, ""},
+		{`isInf(1 / zero) && isInf(-1 / zero) && isInf(1) == false`, true, ""},
+		{`isInf(1 / 0)`, true, ""},
+		// invalid parameters
+		{`abs("1")`, nil, "Invalid parameter to: abs(number) (parameter 1 is string, expected number)"},
+		{`min()`, nil, "Invalid number of parameters to: min(number, ...number) (given 0)"},
+		{`max(1, nil)`, nil, "Invalid parameter to: max(number, ...number) (parameter 2 is <nil>, expected number)"},
+		{`round(1, 2, 3)`, nil, "Invalid number of parameters to: round(number[, int]) (given 3)"},
+		{`round(1, 0.5)`, nil, "Invalid parameter to: round(number[, int]) (parameter 2 is float64, expected int)"},
+		{`clamp(1, 10, 0)`, nil, "Invalid parameter to: clamp(number, number, number) (parameter 3 is 0, expected number no less than the minimum)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+}
+
+func TestDivideByZero(t *testing.T) {
+	vars := map[string]interface{}{"zero": 0}
+	runCases(t, CompileOptions{}, ExecOptions{DivideByZero: DivideByZeroInf}, vars, []execCase{
+		{`1 / zero`, math.Inf(1), ""},
+		{`-1 / 0`, math.Inf(-1), ""},
+		{`1 % zero`, nil, "Integer divide by zero"},
+	})
+	runCases(t, CompileOptions{}, ExecOptions{DivideByZero: DivideByZeroError}, vars, []execCase{
+		{`1 / zero`, nil, "Divide by zero"},
+		{`1 / 0`, nil, "Divide by zero"},
+		{`1 % 0`, nil, "Integer divide by zero"},
+		{`1 / 2`, 0.5, ""},
+	})
+	runCases(t, CompileOptions{}, ExecOptions{DivideByZero: DivideByZeroNil}, vars, []execCase{
+		{`1 / zero`, nil, ""},
+		{`1 / 0 == nil`, true, ""},
+		{`1 % zero`, nil, ""},
+	})
+}
+
+func TestTimeFunctions(t *testing.T) {
+	now := time.Date(2021, time.March, 10, 15, 30, 45, 0, time.UTC) // a Wednesday
+	type account struct {
+		Created time.Time
+		Expires *time.Time
+	}
+	expires := now.Add(time.Hour)
+	vars := map[string]interface{}{
+		"account": &account{Created: now.Add(-36 * time.Hour), Expires: &expires},
+	}
+	tests := []execCase{
+		{`now()`, now, ""},
+		{`account.Expires > now() && account.Created < now()`, true, ""},
+		{`account.Created <= account.Created && account.Created >= account.Created`, true, ""},
+		{`since(account.Created) > duration("24h")`, true, ""},
+		{`since(account.Created) == duration("36h")`, true, ""},
+		{`date(2021, 3, 10) == startOf(now(), "day")`, true, ""},
+		{`date(2021, 3, 10)`, time.Date(2021, time.March, 10, 0, 0, 0, 0, time.UTC), ""},
+		{`format(startOf(now(), "week"), "DateOnly")`, "2021-03-08", ""},
+		{`format(startOf(now(), "month"), "2006-01-02")`, "2021-03-01", ""},
+		{`format(startOf(now(), "year"), "RFC3339")`, "2021-01-01T00:00:00Z", ""},
+		{`format(startOf(now(), "hour"), "Kitchen")`, "3:00PM", ""},
+		{`format(parseTime("2006-01-02 15:04", "2020-05-06 07:08"), "RFC3339")`, "2020-05-06T07:08:00Z", ""},
+		{`parseTime("RFC3339", "2021-03-10T15:30:45Z") == now()`, true, ""},
+		{`year(now()) + month(now()) + day(now())`, float64(2034), ""},
+		{`hour(now()) >= 9 && hour(now()) < 17 && minute(now()) == 30`, true, ""},
+		{`weekday(now())`, "Wednesday", ""},
+		{`hour(inZone(now(), "America/New_York"))`, 10, ""},
+		{`inZone(now(), "America/New_York") == now()`, true, ""},
+		{`duration("90m") == duration("1h30m")`, true, ""},
+		// invalid parameters
+		{`year("2021")`, nil, "Invalid parameter to: year(time.Time) (parameter 1 is string, expected time.Time)"},
+		{`startOf(now(), "fortnight")`, nil, `Invalid parameter to: startOf(time.Time, string) (parameter 2 is "fortnight", expected one of "minute", "hour", "day", "week", "month", or "year")`},
+		{`date(2021, 3.5, 1)`, nil, "Invalid parameter to: date(int, int, int) (parameter 2 is float64, expected int)"},
+		{`duration("soon")`, nil, `time: invalid duration "soon"`},
+		{`inZone(now(), "Nowhere/Special")`, nil, "unknown time zone Nowhere/Special"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{Clock: FixedClock(now)}, vars, tests)
+}
+
+func TestConversionFunctions(t *testing.T) {
+	type label string
+	vars := map[string]interface{}{
+		"json":  map[string]interface{}{"count": "42", "ratio": 0.25, "active": "true", "missing": nil},
+		"label": label("blue"),
+		"ptr":   (*SomeContext)(nil),
+		"hour":  time.Hour,
+		"big":   uint64(math.MaxUint64),
+	}
+	tests := []execCase{
+		{`int(json.count) + 1`, float64(43), ""},
+		{`int(3.9) + int(-3.9)`, float64(0), ""},
+		{`int(" 7.5 ")`, int64(7), ""},
+		{`int(true)`, int64(1), ""},
+		{`float(json.count) * json.ratio`, 10.5, ""},
+		{`float("1e3")`, float64(1000), ""},
+		{`float(false)`, float64(0), ""},
+		{`string(42)`, "42", ""},
+		{`string(0.25) + string(1e21)`, "0.251000000000000000000000", ""},
+		{`string(big)`, "18446744073709551615", ""},
+		{`string(true) + string(nil)`, "truenil", ""},
+		{`string(label) + string(hour)`, "blue1h0m0s", ""},
+		{`bool(json.active) && bool(1) && bool("0") == false`, true, ""},
+		{`typeof(1) + " " + typeof("a") + " " + typeof(nil) + " " + typeof(json) + " " + typeof(label)`, "float64 string <nil> map[string]interface {} epl.label", ""},
+		{`isNil(nil) && isNil(json.missing) && isNil(ptr) && isNil(0) == false`, true, ""},
+		{`isString("a") && isString(label) && isString(1) == false`, true, ""},
+		{`isNumber(1) && isNumber(big) && isNumber(hour) && isNumber("1") == false`, true, ""},
+		// invalid parameters
+		{`int("forty-two")`, nil, `Invalid parameter to: int(any) (parameter 1 is "forty-two", expected numeric string)`},
+		{`int(nil)`, nil, "Invalid parameter to: int(any) (parameter 1 is <nil>, expected number, string, or bool)"},
+		{`int(1e300)`, nil, "Invalid parameter to: int(any) (parameter 1 is 1e+300, expected number in the range of an integer)"},
+		{`float(json)`, nil, "Invalid parameter to: float(any) (parameter 1 is map[string]interface {}, expected number, string, or bool)"},
+		{`bool("yes")`, nil, `Invalid parameter to: bool(any) (parameter 1 is "yes", expected boolean string)`},
+		{`bool(nil)`, nil, "Invalid parameter to: bool(any) (parameter 1 is <nil>, expected bool, number, or string)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+}
+
+func TestRegexFunctions(t *testing.T) {
+	vars := map[string]interface{}{
+		"path":    "/v2/accounts/1234/orders/88",
+		"pattern": "(",
+		"lists":   []string{"a", "b"},
+	}
+	tests := []execCase{
+		{`find("[0-9]+", path)`, "2", ""},
+		{`find("^/v[0-9]+/[a-z]+", path)`, "/v2/accounts", ""},
+		{`find("x+", path)`, "", ""},
+		{`findAll("/[0-9]+", path)`, []string{"/1234", "/88"}, ""},
+		{`findAll("[0-9]+", path, 2)`, []string{"2", "1234"}, ""},
+		{`len(findAll("x", path))`, 0, ""},
+		{`submatch("/accounts/(?P<account>[0-9]+)/orders/(?P<order>[0-9]+)", path).account`, "1234", ""},
+		{`len(submatch("/accounts/(?P<account>[0-9]+)(/users/(?P<user>[0-9]+))?", path))`, 1, ""},
+		{`submatch("/users/(?P<user>[0-9]+)", path) == nil`, true, ""},
+		{`len(submatch("/accounts/([0-9]+)", path))`, 0, ""},
+		{`replaceRegex("[0-9]+", path, "{id}")`, "/v{id}/accounts/{id}/orders/{id}", ""},
+		{`replaceRegex("/(?P<kind>[a-z]+)/([0-9]+)", path, "/${kind}:$2")`, "/v2/accounts:1234/orders:88", ""},
+		{`match("^/v2/", path) && find("orders", path) == "orders"`, true, ""},
+		// invalid parameters
+		{`find(pattern, path)`, nil, "error parsing regexp: missing closing ): `(`"},
+		{`findAll("a", lists)`, nil, "Invalid parameter to: findAll(string, string[, int]) (parameter 2 is []string, expected string)"},
+		{`findAll("a", "b", 1, 2)`, nil, "Invalid number of parameters to: findAll(string, string[, int]) (given 4)"},
+		{`replaceRegex("a", "b", 1)`, nil, "Invalid parameter to: replaceRegex(string, string, string) (parameter 3 is float64, expected string)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+
+	// literal patterns are checked when the program is compiled
+	_, err := Compile(`find("[a-", path) != "" || match("^a+$", path) || submatch("(?P<x", path) == nil`)
+	if errs := Errors(err); len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got: %v", err)
+	} else {
+		for i, o := range []int{5, 59} {
+			if errs[i].Kind != KindCompile || errs[i].Offset != o {
+				t.Errorf("Unexpected error %d: %v (%v at %d)", i, errs[i], errs[i].Kind, errs[i].Offset)
+			}
+		}
+	}
+	// ...unless the name refers to a library function or the builtin is not available
+	lib := NewLibrary("test", "").MustRegister(Function{Name: "find", Func: func(a, b string) string { return a + b }})
+	for _, opts := range []CompileOptions{{Libraries: []*Library{lib}}, {Stdlib: &StdlibOptions{Allow: []string{"len"}}}} {
+		if _, err := CompileWithOptions(`find("(", path)`, opts); err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+	}
+}
+
+func TestRegexCache(t *testing.T) {
+	c := newRegexCache(2)
+	a, _ := c.compile("a")
+	c.compile("b")
+	if x, _ := c.compile("a"); x != a {
+		t.Errorf("Expected the cached expression")
+	}
+	c.compile("c") // evicts "b", the least recently used
+	if _, ok := c.index["b"]; ok || c.order.Len() != 2 {
+		t.Errorf("Expected b to be evicted; cached: %v", c.index)
+	}
+	if x, _ := c.compile("a"); x != a {
+		t.Errorf("Expected the cached expression")
+	}
+	if _, err := c.compile("("); err == nil || c.order.Len() != 2 {
+		t.Errorf("Expected an error and invalid patterns not to be cached")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if x, err := c.compile(fmt.Sprintf("^%d$", (i+j)%5)); err != nil || !x.MatchString(fmt.Sprint((i+j)%5)) {
+					t.Errorf("Unexpected expression: %v, %v", x, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.order.Len() != 2 || len(c.index) != 2 {
+		t.Errorf("Expected the cache to be bounded: %d, %d", c.order.Len(), len(c.index))
+	}
+}
+
+func TestNetworkFunctions(t *testing.T) {
+	vars := map[string]interface{}{
+		"remoteAddr": "10.1.2.3:5555",
+		"remoteV6":   "[2001:db8::1]:443",
+		"netIP":      net.ParseIP("192.168.1.20"),
+		"addr":       netip.MustParseAddr("10.1.2.3"),
+		"host":       "API.Example.com:8443",
+		"nets":       []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.0.0/16")},
+		"ports":      map[int]string{80: "http", 443: "https"},
+		"tags":       map[interface{}]bool{"edge": true, 443: true},
+		"list":       []int{1, 2},
+		"attrs":      map[string]int{"a": 1},
+	}
+	tests := []execCase{
+		{`ip(remoteAddr) in cidr("10.0.0.0/8")`, true, ""},
+		{`ip(remoteAddr) in cidr("10.1.3.0/24")`, false, ""},
+		{`ip(remoteV6) in cidr("2001:db8::/32") && isIPv6(ip(remoteV6))`, true, ""},
+		{`"10.255.0.1" in cidr("10.0.0.0/8") && netIP in cidr("192.168.1.0/24")`, true, ""},
+		{`ip("::ffff:10.0.0.1") in cidr("10.0.0.0/8")`, true, ""},
+		{`cidr("10.1.2.3/8") == cidr("10.0.0.0/8")`, true, ""},
+		{`cidr("10.0.0.0/8") in nets && 443 in ports && (8080 in ports) == false`, true, ""},
+		{`"edge" in tags && (list in tags) == false && (attrs in tags) == false`, true, ""},
+		{`ip(remoteAddr) == addr && addr == "10.1.2.3" && "10.1.2.3" == addr`, true, ""},
+		{`netIP == "192.168.1.20" && netIP == ip("::ffff:192.168.1.20") && netIP != addr`, true, ""},
+		{`(ip("10.0.0.2") > ip("10.0.0.10")) == false && addr < "10.1.2.4"`, true, ""},
+		{`addr == "not an address"`, false, ""},
+		{`isPrivateIP(remoteAddr)`, nil, `Invalid parameter to: isPrivateIP(ip) (parameter 1 is "10.1.2.3:5555", expected IP address)`},
+		{`isPrivateIP(ip(remoteAddr)) && isPrivateIP(netIP) && isPrivateIP("fd00::1") && isPrivateIP("8.8.8.8") == false`, true, ""},
+		{`isIPv6("::ffff:10.0.0.1") || isIPv6(addr)`, false, ""},
+		{`hostMatch("*.example.com", host)`, true, ""},
+		{`hostMatch("*.example.com", "example.com") || hostMatch("*.example.com", "a.b.example.com")`, false, ""},
+		{`hostMatch("api-?.example.com", "api-2.example.com.") && hostMatch("example.com", "EXAMPLE.COM")`, true, ""},
+		// invalid parameters
+		{`ip("10.0.0.256")`, nil, `Invalid parameter to: ip(string) (parameter 1 is "10.0.0.256", expected IP address)`},
+		{`ip(10)`, nil, "Invalid parameter to: ip(string) (parameter 1 is float64, expected IP address or string)"},
+		{`cidr("10.0.0.0")`, nil, `Invalid parameter to: cidr(string) (parameter 1 is "10.0.0.0", expected network in CIDR notation)`},
+		{`hostMatch("[", host)`, nil, `Invalid parameter to: hostMatch(string, string) (parameter 1 is "[", expected valid pattern)`},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+
+	// an address which is not an IP cannot be a member of a network
+	p, err := Compile(`num in cidr("10.0.0.0/8")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Exec(map[string]interface{}{"num": 10})
+	if x, ok := err.(*Error); !ok || x.Kind != KindRuntime || x.Message != "Invalid left operand to in: int (expected an IP address)" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// precedence, as in the example of the specification
+	order := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 1; i < len(order); i++ {
+		a, err := ParseVersion(order[i-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseVersion(order[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Compare(b) >= 0 || b.Compare(a) <= 0 || a.Compare(a) != 0 {
+			t.Errorf("Expected %v < %v", a, b)
+		}
+	}
+
+	for _, e := range []struct {
+		Source string
+		Result Version
+		Error  bool
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"v2.3", Version{Major: 2, Minor: 3}, false},
+		{"1.0.0-rc.1+build.5", Version{Major: 1, Prerelease: "rc.1", Build: "build.5"}, false},
+		{"1.0.0+20130313144700", Version{Major: 1, Build: "20130313144700"}, false},
+		{"01.2.3", Version{}, true},
+		{"1.2.3-01", Version{}, true},
+		{"1.2.3.4", Version{}, true},
+		{"1.2-beta", Version{}, true},
+		{"1.2.3-", Version{}, true},
+		{"1.2.3-a..b", Version{}, true},
+		{"", Version{}, true},
+	} {
+		v, err := ParseVersion(e.Source)
+		if e.Error != (err != nil) {
+			t.Errorf("%q: Unexpected error: %v", e.Source, err)
+		} else if v != e.Result {
+			t.Errorf("%q: Expected %#v, got %#v", e.Source, e.Result, v)
+		}
+	}
+
+	for _, e := range []struct {
+		Constraint string
+		Match      []string
+		Miss       []string
+	}{
+		{">=1.2 <2.0", []string{"1.2.0", "1.9.9", "2.0.0-rc.1"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.99.0"}, []string{"2.0.0"}},
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4"}},
+		{"1.2", []string{"1.2.0", "1.2.99"}, []string{"1.3.0"}},
+		{"1.x", []string{"1.0.0", "1.9.0"}, []string{"2.0.0", "0.9.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.2.2", "1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"^1.4 || ~2.0.1", []string{"1.4.0", "2.0.5"}, []string{"1.3.0", "2.1.0"}},
+	} {
+		c, err := parseVersionConstraint(e.Constraint)
+		if err != nil {
+			t.Errorf("%q: Could not parse: %v", e.Constraint, err)
+			continue
+		}
+		for i, l := range [][]string{e.Match, e.Miss} {
+			for _, s := range l {
+				if v, _ := ParseVersion(s); c.match(v) != (i == 0) {
+					t.Errorf("%q: Expected match of %v to be %v", e.Constraint, s, i == 0)
+				}
+			}
+		}
+	}
+	for _, e := range []string{"", ">=1.2 ||", "=>1.2", "~*", "!=1.2", "1.2-beta", ">=x.2"} {
+		if _, err := parseVersionConstraint(e); err == nil {
+			t.Errorf("%q: Expected an error", e)
+		}
+	}
+}
+
+func TestVersionFunctions(t *testing.T) {
+	current := Version{Major: 2, Minor: 4, Patch: 1}
+	vars := map[string]interface{}{
+		"app":     map[string]interface{}{"Version": "2.3.1-beta.2"},
+		"current": &current,
+	}
+	tests := []execCase{
+		{`semver(app.Version) >= semver("2.3.0")`, true, ""},
+		{`semver(app.Version) < "2.3.1" && semver(app.Version) > "2.3.1-beta.1"`, true, ""},
+		{`semver("v2.4.1+build.7") == current && current == "2.4.1" && "2.4.1" == current`, true, ""},
+		{`current != semver("2.4.1-rc.1") && "2.4.0" < current`, true, ""},
+		{`semver(app.Version).Minor + semver(app.Version).Patch`, float64(4), ""},
+		{`string(semver("1.2"))`, "1.2.0", ""},
+		{`satisfies(app.Version, ">=1.2 <2.0")`, false, ""},
+		{`satisfies(current, "^2.3") && satisfies("1.9.0", ">=1.2 <2.0")`, true, ""},
+		{`current == "not a version"`, false, ""},
+		// invalid parameters
+		{`semver("2.3.0.1")`, nil, `Invalid parameter to: semver(string) (parameter 1 is "2.3.0.1", expected semantic version)`},
+		{`semver(2)`, nil, "Invalid parameter to: semver(string) (parameter 1 is float64, expected version or string)"},
+		{`satisfies(current, ">>2")`, nil, `Invalid parameter to: satisfies(version, string) (parameter 2 is ">>2", expected version constraint)`},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+}
+
+func TestUUIDFunctions(t *testing.T) {
+	type otherUUID [16]byte
+	id := UUID{0x95, 0x15, 0x97, 0x6f, 0xcd, 0xb4, 0x4e, 0x56, 0xbd, 0x07, 0xb1, 0xae, 0x6e, 0xfc, 0x00, 0xda}
+	vars := map[string]interface{}{
+		"user":                                 map[string]interface{}{"ID": "9515976f-cdb4-4e56-bd07-b1ae6efc00da"},
+		"id":                                   &id,
+		"bytes":                                [16]byte(id),
+		"other":                                otherUUID(id),
+		"9515976f-cdb4-4e56-bd07-b1ae6efc00db": "defined",
+	}
+	tests := []execCase{
+		{`uuid(user.ID) == u:9515976F-CDB4-4E56-BD07-B1AE6EFC00DA`, true, ""},
+		{`u:9515976fcdb44e56bd07b1ae6efc00da == user.ID && user.ID == u:9515976fcdb44e56bd07b1ae6efc00da`, true, ""},
+		{`id == user.ID && "9515976F-CDB4-4E56-BD07-B1AE6EFC00DA" == id && id != uuid("{00000000-0000-0000-0000-000000000000}")`, true, ""},
+		{`bytes == user.ID || other == user.ID || id == bytes || id == other || bytes == other`, false, ""}, // only a UUID is a UUID
+		{`u:9515976f-cdb4-4e56-bd07-b1ae6efc00db`, "defined", ""},
+		{`u:9515976f-cdb4-4e56-bd07-b1ae6efc00da < u:9515976f-cdb4-4e56-bd07-b1ae6efc00dc`, true, ""},
+		{`string(uuid("urn:uuid:9515976F-CDB4-4E56-BD07-B1AE6EFC00DA"))`, "9515976f-cdb4-4e56-bd07-b1ae6efc00da", ""},
+		{`uuidVersion(user.ID) + uuidVersion(u:017f22e2-79b0-7cc3-98c4-dc0c0c07398f)`, float64(11), ""},
+		{`uuidVariant(id) + " " + uuidVariant("00000000-0000-0000-0000-000000000000")`, "RFC9562 NCS", ""},
+		{`isUUID(user.ID) && isUUID(id) && isUUID(other) == false && isUUID("9515976f-cdb44e56-bd07-b1ae6efc00da") == false && isUUID(1) == false`, true, ""},
+		{`user.ID == "not a uuid" || id == "not a uuid"`, false, ""},
+		// invalid parameters
+		{`uuid("9515976f-cdb4-4e56-bd07")`, nil, `Invalid parameter to: uuid(string) (parameter 1 is "9515976f-cdb4-4e56-bd07", expected UUID)`},
+		{`uuidVersion(1)`, nil, "Invalid parameter to: uuidVersion(uuid) (parameter 1 is float64, expected UUID or string)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+
+	// an identifier which cannot be resolved for any other reason is an error
+	p, err := Compile(`u:9515976f-cdb4-4e56-bd07-b1ae6efc00da`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Exec(func(n string) (interface{}, error) { return nil, fmt.Errorf("Unavailable") })
+	if x, ok := err.(*Error); !ok || x.Kind != KindRuntime {
+		t.Errorf("Expected a runtime error, got: %v", err)
+	}
+
+	// a UUID identifier is a UUID when the type of a result is checked
+	if _, err := CompileWithOptions(`u:9515976f-cdb4-4e56-bd07-b1ae6efc00da`, CompileOptions{Result: reflect.TypeOf(UUID{}), Schema: map[string]reflect.Type{}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestEncodingFunctions(t *testing.T) {
+	vars := map[string]interface{}{
+		"user":  map[string]interface{}{"ID": "u-1"},
+		"data":  []byte("hello"),
+		"max":   uint64(math.MaxUint64),
+		"min":   int64(math.MinInt64),
+		"large": uint64(math.MaxUint64 - 1),
+	}
+	tests := []execCase{
+		{`base64Encode("hello?")`, "aGVsbG8/", ""},
+		{`base64Decode("aGVsbG8/") + base64Decode("aGVsbG8_") + base64Decode("aGk")`, "hello?hello?hi", ""},
+		{`base64Decode(base64Encode(data))`, "hello", ""},
+		{`hexEncode("hi!") + " " + hexEncode(data)`, "686921 68656c6c6f", ""},
+		{`urlEncode("a b&c=d/é")`, "a+b%26c%3Dd%2F%C3%A9", ""},
+		{`urlDecode("a+b%26c%3Dd%2F%C3%A9")`, "a b&c=d/é", ""},
+		{`sha256("hello") == sha256(data)`, true, ""},
+		{`sha256("hello")`, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", ""},
+		{`fnv64("hello")`, uint64(11831194018420276491), ""},
+		{`fnv64("hello") % 100`, int64(91), ""},
+		{`fnv64(user.ID + "exp42") % 100 < 20`, true, ""},
+		{`max % 1000`, int64(615), ""},
+		{`large % max`, uint64(math.MaxUint64 - 1), ""},
+		{`min % 10`, int64(-8), ""},
+		{`min % max`, int64(math.MinInt64), ""},
+		// invalid parameters
+		{`base64Decode("a")`, nil, `Invalid parameter to: base64Decode(string) (parameter 1 is "a", expected base64)`},
+		{`urlDecode("%zz")`, nil, `Invalid parameter to: urlDecode(string) (parameter 1 is "%zz", expected URL-encoded string)`},
+		{`sha256(1)`, nil, "Invalid parameter to: sha256(string) (parameter 1 is float64, expected string or []byte)"},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+
+	// an encoding which would exceed the limit is not produced
+	for _, e := range []string{`base64Encode(data)`, `hexEncode(data)`} {
+		p, err := Compile(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.ExecWithOptions(gocontext.Background(), vars, ExecOptions{MaxStringLength: 5})
+		var limit *LimitError
+		if !errors.As(err, &limit) || limit.Limit != LimitStringLength {
+			t.Errorf("%s: Expected a string length limit error, got <%v>", e, err)
+		}
+	}
+}
+
+func TestURLFunctions(t *testing.T) {
+	hook, _ := url.Parse("https://hooks.example.com/v1/events?source=github&source=gitlab")
+	vars := map[string]interface{}{
+		"webhook": map[string]interface{}{
+			"URL":    "https://API.example.com:8443/v2/orders?id=42&ref=mail#top",
+			"Sender": "Billing Team <billing@Example.COM>",
+		},
+		"hook": hook,
+	}
+	tests := []execCase{
+		{`url(webhook.URL).Scheme + " " + url(webhook.URL).Host + " " + url(webhook.URL).Port`, "https API.example.com 8443", ""},
+		{`url(webhook.URL).Path + "#" + url(webhook.URL).Fragment`, "/v2/orders#top", ""},
+		{`query(webhook.URL, "id") + query(url(webhook.URL), "ref") + query(webhook.URL, "missing")`, "42mail", ""},
+		{`"ref" in url(webhook.URL).Query && url(webhook.URL).Query.id[0] == "42"`, true, ""},
+		{`query(hook, "source") + " " + url(hook).Host + " " + url("http://[::1]/").Host`, "github hooks.example.com ::1", ""},
+		{`string(url(webhook.URL))`, "https://API.example.com:8443/v2/orders?id=42&ref=mail#top", ""},
+		{`url(webhook.URL) == url(webhook.URL) && url(webhook.URL) != url(hook) && url(hook) != nil`, true, ""},
+		{`isURL(webhook.URL) && isURL("/relative/path") == false && isURL("mailto:a@example.com") == false`, true, ""},
+		{`email(webhook.Sender).Domain == "example.com" && email(webhook.Sender).Local == "billing"`, true, ""},
+		{`email(webhook.Sender).Name + ": " + email(webhook.Sender).Address`, "Billing Team: billing@Example.COM", ""},
+		{`hasSuffix(email("ops@mail.example.com").Domain, ".example.com")`, true, ""},
+		{`isEmail("billing@example.com") && isEmail(webhook.Sender) == false && isEmail("billing") == false`, true, ""},
+		// invalid parameters
+		{`url(":")`, nil, `Invalid parameter to: url(string) (parameter 1 is ":", expected URL)`},
+		{`query(webhook.URL, 1)`, nil, "Invalid parameter to: query(url, string) (parameter 2 is float64, expected string)"},
+		{`email("billing at example.com")`, nil, `Invalid parameter to: email(string) (parameter 1 is "billing at example.com", expected email address)`},
+	}
+	runCases(t, CompileOptions{}, ExecOptions{}, vars, tests)
+}
+
+func TestRuntimeOptions(t *testing.T) {
+	vars := map[string]interface{}{
+		"today": func(s *State) string {
+			return s.Runtime.Clock.Now().Format("2006-01-02")
+		},
+		"roll": func(s *State) int {
+			return s.Runtime.Random.Intn(1000000)
+		},
+		"note": func(s *State, m string) bool {
+			s.Runtime.Logger.Printf("Note: %s", m)
+			return true
+		},
+	}
+	p, err := Compile(`printf("Today is %v", today()) && note("hello")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rolls []interface{}
+	for i := 0; i < 2; i++ {
+		out, logs := &bytes.Buffer{}, &bytes.Buffer{}
+		opts := ExecOptions{
+			Stdout: out,
+			Clock:  FixedClock(time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)),
+			Random: rand.NewSource(99),
+			Logger: log.New(logs, "", 0),
+		}
+		_, err := p.ExecWithOptions(gocontext.Background(), vars, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := out.String(); v != "Today is 2020-01-01\n" {
+			t.Errorf("Unexpected output: %q", v)
+		}
+		if v := logs.String(); v != "Note: hello\n" {
+			t.Errorf("Unexpected log: %q", v)
+		}
+		r, err := Compile(`roll()`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := r.ExecWithOptions(gocontext.Background(), vars, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rolls = append(rolls, v)
+	}
+	// the same seed produces the same values
+	if rolls[0] != rolls[1] {
+		t.Errorf("Expected seeded random values to be equal: %v != %v", rolls[0], rolls[1])
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	var calls int
+	lib := NewLibrary("test", "Functions for testing.")
+	lib.MustRegister(Function{
+		Name:   "double",
+		Doc:    "Double a number.",
+		Params: []Param{{"n", "The number to double"}},
+		Pure:   true,
+		Func: func(n float64) float64 {
+			calls++
+			return n * 2
+		},
+	})
+	lib.MustRegister(Function{
+		Name:   "sum",
+		Doc:    "Sum numbers.",
+		Params: []Param{{"first", ""}, {"rest", ""}},
+		Func: func(first float64, rest ...interface{}) float64 {
+			for _, e := range rest {
+				first += e.(float64)
+			}
+			return first
+		},
+	})
+	lib.MustRegister(Function{
+		Name: "greet",
+		Func: func(s *State, name string) (string, error) {
+			return "Hello, " + name, nil
+		},
+	})
+
+	invalid := []Function{
+		{Name: "", Func: func() {}},
+		{Name: "1st", Func: func() {}},
+		{Name: "double", Func: func() {}},
+		{Name: "notFunc", Func: 123},
+		{Name: "nilFunc", Func: (func())(nil)},
+		{Name: "badReturn", Func: func() (int, int) { return 0, 0 }},
+		{Name: "badParams", Params: []Param{{"a", ""}}, Func: func() {}},
+		{Name: "pureState", Pure: true, Func: func(s *State) int { return 0 }},
+		{Name: "pureVoid", Pure: true, Func: func() {}},
+	}
+	for _, e := range invalid {
+		if err := lib.Register(e); err == nil {
+			t.Errorf("%v: Expected a registration error", e.Name)
+		}
+	}
+
+	if v := lib.Function("sum").Signature(); v != "sum(first, ...rest)" {
+		t.Errorf("Unexpected signature: %v", v)
+	}
+	if v := lib.Function("greet").Signature(); v != "greet(arg1)" {
+		t.Errorf("Unexpected signature: %v", v)
+	}
+	doc := &strings.Builder{}
+	if err := lib.Describe(doc); err != nil {
+		t.Fatal(err)
+	}
+	if v, x := doc.String(), "test\n  Functions for testing.\n\n  double(n) (pure)\n    Double a number.\n    n: The number to double\n\n  sum(first, ...rest)\n    Sum numbers.\n\n  greet(arg1)\n"; v != x {
+		t.Errorf("Unexpected description: %q", v)
+	}
+
+	// library functions take precedence over the context
+	vars := map[string]interface{}{
+		"x":     float64(3),
+		"greet": func(s string) string { return "Bye" },
+	}
+	runCases(t, CompileOptions{Libraries: []*Library{lib}}, ExecOptions{}, vars, []execCase{
+		{`double(x)`, float64(6), ""},
+		{`sum(1, 2, 3) == 6`, true, ""},
+		{`sum(1)`, float64(1), ""},
+		{`greet("you")`, "Hello, you", ""},
+		{`len(greet("you"))`, 10, ""},
+		{`double()`, testCompileError, ""},
+		{`double(1, 2)`, testCompileError, ""},
+		{`sum()`, testCompileError, ""},
+		{`x.double(1, 2) || true`, testRuntimeError, ""}, // methods are not checked
+	})
+
+	// pure functions with constant arguments are evaluated when compiled
+	calls = 0
+	p, err := CompileWithOptions(`double(2) + double(x)`, CompileOptions{Libraries: []*Library{lib}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call when compiling, got %d", calls)
+	}
+	for i := 0; i < 2; i++ {
+		if v, err := p.Exec(vars); err != nil || v != float64(10) {
+			t.Errorf("Expected <10>, got <%v> (%v)", v, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls after executing, got %d", calls)
+	}
+
+	// a function may only be provided by one library
+	other := NewLibrary("other", "").MustRegister(Function{Name: "double", Func: func(n float64) float64 { return n }})
+	if _, err := CompileWithOptions(`1`, CompileOptions{Libraries: []*Library{lib, other}}); err == nil {
+		t.Errorf("Expected an error for conflicting libraries")
+	}
+
+	// libraries are not encoded, so they are provided when executing
+	p, err = CompileWithOptions(`greet("you")`, CompileOptions{Libraries: []*Library{lib}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.Bytecode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := LoadBytecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := x.ExecWithOptions(gocontext.Background(), vars, ExecOptions{Libraries: []*Library{lib}}); err != nil || v != "Hello, you" {
+		t.Errorf("Expected <Hello, you>, got <%v> (%v)", v, err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"num": 123,
+		"big": uint64(1 << 63),
+		"arr": []string{"Zero", "One"},
+		"ctx": &SomeContext{StringField: "Hello"},
+	}
+	compile := func(e string) *Program {
+		p, err := Compile(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	if v, err := compile(`num > 100`).ExecBool(vars); err != nil || v != true {
+		t.Errorf("Expected <true>, got <%v> (%v)", v, err)
+	}
+	if v, err := compile(`num`).ExecBool(vars); err != nil || v != true {
+		t.Errorf("Expected <true>, got <%v> (%v)", v, err)
+	}
+	if v, err := compile(`ctx.StringField`).ExecBool(vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := compile(`ctx.StringField + "!"`).ExecString(vars); err != nil || v != "Hello!" {
+		t.Errorf("Expected <Hello!>, got <%v> (%v)", v, err)
+	}
+	if v, err := compile(`num`).ExecString(vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := compile(`num`).ExecFloat(vars); err != nil || v != 123 {
+		t.Errorf("Expected <123>, got <%v> (%v)", v, err)
+	}
+
+	if v, err := Eval[int](compile(`num % 100`), vars); err != nil || v != 23 {
+		t.Errorf("Expected <23>, got <%v> (%v)", v, err)
+	}
+	if v, err := Eval[int](compile(`num / 2`), vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := Eval[uint8](compile(`num * 3`), vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := Eval[uint64](compile(`big`), vars); err != nil || v != 1<<63 {
+		t.Errorf("Expected <%v>, got <%v> (%v)", uint64(1<<63), v, err)
+	}
+	if v, err := Eval[int64](compile(`big`), vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := Eval[[]string](compile(`arr`), vars); err != nil || !reflect.DeepEqual(v, vars["arr"]) {
+		t.Errorf("Expected <%v>, got <%v> (%v)", vars["arr"], v, err)
+	}
+	if v, err := Eval[*SomeContext](compile(`nil`), vars); err != nil || v != nil {
+		t.Errorf("Expected <nil>, got <%v> (%v)", v, err)
+	}
+	if v, err := Eval[any](compile(`nil`), vars); err != nil || v != nil {
+		t.Errorf("Expected <nil>, got <%v> (%v)", v, err)
+	}
+	if v, err := Eval[error](compile(`nil`), vars); err != nil || v != nil {
+		t.Errorf("Expected <nil>, got <%v> (%v)", v, err)
+	}
+	if v, err := Eval[any](compile(`num`), vars); err != nil || v != 123 {
+		t.Errorf("Expected <123>, got <%v> (%v)", v, err)
+	}
+	if v, err := Eval[int](compile(`nil`), vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+	if v, err := Eval[string](compile(`arr`), vars); err == nil {
+		t.Errorf("Expected an error, got <%v>", v)
+	}
+}
+
+func TestResultType(t *testing.T) {
+	schema := Schema{
+		"num":  reflect.TypeOf(0),
+		"name": reflect.TypeOf(""),
+		"ctx":  reflect.TypeOf(&SomeContext{}),
+		"any":  reflect.TypeOf((*interface{})(nil)).Elem(),
+		"m":    reflect.TypeOf(map[string]int{}),
+		"f":    reflect.TypeOf(func() string { return "" }),
+	}
+	lib := NewLibrary("test", "").MustRegister(Function{Name: "name", Func: func() int { return 0 }})
+	typeOfSlice := reflect.TypeOf([]string{})
+	tests := []struct {
+		Source string
+		Result reflect.Type
+		Error  bool
+	}{
+		{`num`, typeOfBool, false},
+		{`name`, typeOfBool, true},
+		{`name == "x"`, typeOfBool, false},
+		{`ctx.IntField + 1`, typeOfBool, false},
+		{`ctx.StringField`, typeOfBool, true},
+		{`ctx.StringFieldMethod()`, typeOfBool, true},
+		{`ctx.StringFieldMethod`, typeOfBool, true},
+		{`ctx.RecursiveFieldMethod().BoolField`, typeOfBool, false},
+		{`ctx.RecursiveFieldMethod().StringField`, typeOfBool, true},
+		{`ctx.SliceField[0]`, typeOfBool, true},
+		{`ctx.SliceField`, typeOfSlice, false},
+		{`ctx.SliceField`, typeOfString, true},
+		{`len(ctx.SliceField)`, typeOfBool, false},
+		{`m.key`, typeOfBool, false},
+		{`m["key"]`, typeOfString, true},
+		{`f()`, typeOfString, false},
+		{`f()`, typeOfFloat64, true},
+		{`num % 2`, typeOfString, true},
+		{`name + num`, typeOfString, false},
+		{`"a" + "b"`, typeOfFloat64, true},
+		{`unknown`, typeOfBool, false},
+		{`any`, typeOfString, false},
+		{`num`, typeOfSlice, true},
+	}
+	for _, e := range tests {
+		_, err := CompileWithOptions(e.Source, CompileOptions{Schema: schema, Result: e.Result})
+		if e.Error && err == nil {
+			t.Errorf("%s: Expected a compile error", e.Source)
+		} else if !e.Error && err != nil {
+			t.Errorf("%s: Unexpected compile error: %v", e.Source, err)
+		}
+	}
+
+	// library functions take precedence over the schema
+	if _, err := CompileWithOptions(`name()`, CompileOptions{Schema: schema, Result: typeOfString, Libraries: []*Library{lib}}); err == nil {
+		t.Errorf("Expected a compile error")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	failure := fmt.Errorf("This error is intentional.")
+	vars := map[string]interface{}{
+		"fail": func() error { return failure },
+		"m":    map[string]int{"a": 1},
+	}
+	tests := []struct {
+		Source  string
+		Kind    ErrorKind
+		Message string
+		Line    int
+		Column  int
+		Offset  int
+		Length  int
+		Excerpt string
+	}{
+		{"a &&\n  (b || ]", KindSyntax, "Illegal token in primary expression: <']' \"]\">", 2, 9, 13, 1, "2:   (b || ]\n           ^\n"},
+		{`"é" + "ü`, KindSyntax, "Unexpected end-of-input", 1, 7, 7, 3, "1: \"é\" + \"ü\n         ^^\n"},
+		{`"é" == undefined`, KindUndefined, "Undefined variable 'undefined'", 1, 8, 8, 9, ""},
+		{`m["b"]`, KindUndefined, "No such key: b", 1, 1, 0, 6, ""},
+		{`undefined()`, KindUndefined, "No such function 'undefined'", 1, 1, 0, 11, ""},
+		{`1 + fail()`, KindRuntime, "Function fail failed", 1, 5, 4, 6, ""},
+		{`10 % 0`, KindRuntime, "Integer divide by zero", 1, 1, 0, 6, ""},
+		{`"a" < 1`, KindRuntime, "", 1, 1, 0, 3, ""},
+		{`len(1, 2, 3)`, KindRuntime, "", 1, 1, 0, 12, ""},
+	}
+	for _, e := range tests {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			var err error
+			p, err := CompileWithOptions(e.Source, CompileOptions{Engine: g})
+			if err == nil {
+				_, err = p.Exec(vars)
+			}
+			var x *Error
+			if !errors.As(err, &x) {
+				t.Errorf("[%v] %s: Expected an *Error, got <%T> %v", g, e.Source, err, err)
+				continue
+			}
+			if x.Kind != e.Kind {
+				t.Errorf("[%v] %s: Expected kind <%v>, got <%v>", g, e.Source, e.Kind, x.Kind)
+			}
+			if e.Message != "" && x.Message != e.Message {
+				t.Errorf("[%v] %s: Expected message <%v>, got <%v>", g, e.Source, e.Message, x.Message)
+			}
+			if x.Line != e.Line || x.Column != e.Column || x.Offset != e.Offset || x.Length != e.Length {
+				t.Errorf("[%v] %s: Expected position <%d:%d, %d+%d>, got <%d:%d, %d+%d>", g, e.Source, e.Line, e.Column, e.Offset, e.Length, x.Line, x.Column, x.Offset, x.Length)
+			}
+			if e.Excerpt != "" && x.Excerpt() != e.Excerpt {
+				t.Errorf("[%v] %s: Expected excerpt <%q>, got <%q>", g, e.Source, e.Excerpt, x.Excerpt())
+			}
+			if !strings.HasPrefix(x.Error(), x.Message) || !strings.HasSuffix(x.Error(), x.Excerpt()) {
+				t.Errorf("[%v] %s: Expected the error to include the message and excerpt: %v", g, e.Source, x)
+			}
+		}
+	}
+
+	// causes are available via errors.Is and errors.As
+	p, err := Compile(`fail()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Exec(vars)
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the cause to be available, got <%v>", err)
+	}
+
+	// errors without a position have no excerpt
+	_, err = CompileWithOptions(`1 + 2`, CompileOptions{MaxSourceLength: 2})
+	var x *Error
+	if !errors.As(err, &x) || x.Kind != KindCompile || x.Line != 0 || x.Excerpt() != "" || x.Error() != x.Message {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		Source  string
+		Opts    ExcerptOptions
+		Excerpt string
+	}{
+		{"a &&\n  (b || ]", ExcerptOptions{}, "2:   (b || ]\n           ^\n"},
+		{"a +", ExcerptOptions{}, "1: a +\n      ^\n"},
+		{"a +\n", ExcerptOptions{}, "2: \n   ^\n"},
+		{"\t(a ||\tb) ]", ExcerptOptions{}, "1:     (a ||   b) ]\n                  ^\n"},
+		{"\t(a ||\tb) ]", ExcerptOptions{TabWidth: 2}, "1:   (a || b) ]\n              ^\n"},
+		{`"日本語" + é + 1`, ExcerptOptions{}, "1: \"日本語\" + é + 1\n              ^\n"},
+		{`"日本語" + "🙂`, ExcerptOptions{}, "1: \"日本語\" + \"🙂\n              ^^^\n"},
+		{"a\r\n&& b +\r\n* c", ExcerptOptions{}, "3: * c\n   ^\n"},
+		{"a\n&& b\n&& c +\n* d\n&& e\n&& f\n&& g", ExcerptOptions{Context: 2}, "2: && b\n3: && c +\n4: * d\n   ^\n5: && e\n6: && f\n"},
+		{strings.Repeat("a ||\n", 10) + ")", ExcerptOptions{Context: 2}, " 9: a ||\n10: a ||\n11: )\n    ^\n"},
+		{"a + )", ExcerptOptions{Color: true}, "\x1b[2m1: \x1b[0ma + \x1b[1;31m)\x1b[0m\n       \x1b[1;31m^\x1b[0m\n"},
+	}
+	for _, e := range tests {
+		_, err := Compile(e.Source)
+		errs := Errors(err)
+		if len(errs) < 1 {
+			t.Errorf("%q: Expected an error", e.Source)
+			continue
+		}
+		if x := errs[0].ExcerptWithOptions(e.Opts); x != e.Excerpt {
+			t.Errorf("%q: Expected excerpt:\n%s\ngot:\n%s", e.Source, e.Excerpt, x)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	add := NewLibrary("test", "")
+	add.MustRegister(Function{Name: "add", Params: []Param{{Name: "a"}, {Name: "b"}}, Func: func(a, b float64) float64 { return a + b }})
+	tests := []struct {
+		Source string
+		Opts   CompileOptions
+		Errors []string
+	}{
+		{`a &&`, CompileOptions{}, []string{
+			"1:5 Unexpected end-of-input",
+		}},
+		{`a b c || d`, CompileOptions{}, []string{
+			`1:3 Syntax error: <Ident "b">`,
+		}},
+		{`f(a b, c +) || d[) && (e`, CompileOptions{}, []string{
+			"1:5 Invalid token: Ident (expected: ',', ')')",
+			`1:11 Illegal token in primary expression: <')' ")">`,
+			`1:18 Illegal token in primary expression: <')' ")">`,
+			"1:25 Unexpected end-of-input",
+		}},
+		{"((a) || ])\n  && b + * c\n  || ", CompileOptions{}, []string{
+			`1:9 Illegal token in primary expression: <']' "]">`,
+			`1:10 Syntax error: <')' ")">`,
+			`2:10 Illegal token in primary expression: <'*' "*">`,
+			"3:6 Unexpected end-of-input",
+		}},
+		{`a ) || "unterminated`, CompileOptions{}, []string{
+			`1:3 Syntax error: <')' ")">`,
+			"1:8 Unexpected end-of-input",
+		}},
+		{`(a + ) || b || ((((c))))`, CompileOptions{MaxDepth: 4}, []string{
+			`1:6 Illegal token in primary expression: <')' ")">`,
+			"1:18 Expression is nested too deeply (maximum depth: 4)",
+		}},
+		{`add(1) || add(1, 2) || add(1, 2, 3)`, CompileOptions{Libraries: []*Library{add}, Result: typeOfString}, []string{
+			"1:1 Function add(a, b) takes 2 arguments but is given 1",
+			"1:24 Function add(a, b) takes 2 arguments but is given 3",
+			"1:1 Expression produces bool, which cannot be used as string",
+		}},
+	}
+	for _, e := range tests {
+		_, err := CompileWithOptions(e.Source, e.Opts)
+		var l ErrorList
+		if !errors.As(err, &l) {
+			t.Errorf("%s: Expected an ErrorList, got <%T> %v", e.Source, err, err)
+			continue
+		}
+		var errs []string
+		for _, x := range Errors(err) {
+			errs = append(errs, fmt.Sprintf("%d:%d %s", x.Line, x.Column, x.Message))
+		}
+		if !reflect.DeepEqual(e.Errors, errs) {
+			t.Errorf("%s: Expected errors:\n  %s\ngot:\n  %s", e.Source, strings.Join(e.Errors, "\n  "), strings.Join(errs, "\n  "))
+		}
+		var x *Error
+		if !errors.As(err, &x) || x != l[0] {
+			t.Errorf("%s: Expected the first error to be matched, got %v", e.Source, x)
+		}
+	}
+	if Errors(fmt.Errorf("Not an EPL error")) != nil {
+		t.Errorf("Expected no errors")
+	}
+}
+
+/**
+ * A case for runCases. The result is the value the source produces or, if
+ * an error is expected, testCompileError or testRuntimeError. When Error is
+ * set, a runtime error is expected with that message, which is the message
+ * of its cause if it has one.
+ */
+type execCase struct {
+	Source string
+	Result interface{}
+	Error  string
+}
+
+/**
+ * Compile and execute each case with every engine, checking its result
+ */
+func runCases(t *testing.T, copts CompileOptions, xopts ExecOptions, vars interface{}, cases []execCase) {
+	t.Helper()
+	for _, e := range cases {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			copts.Engine = g
+			p, err := CompileWithOptions(e.Source, copts)
+			if err != nil {
+				if e.Result != testCompileError {
+					t.Errorf("[%v] %s: Could not compile: %v", g, e.Source, err)
+				}
+				continue
+			} else if e.Result == testCompileError {
+				t.Errorf("[%v] %s: Expected a compile error", g, e.Source)
+				continue
+			}
+			v, err := p.ExecWithOptions(gocontext.Background(), vars, xopts)
+			if e.Error != "" {
+				var x *Error
+				if !errors.As(err, &x) || (x.Cause != nil && x.Cause.Error() != e.Error) || (x.Cause == nil && x.Message != e.Error) {
+					t.Errorf("[%v] %s: Expected error <%v>, got <%v>", g, e.Source, e.Error, err)
+				}
+			} else if e.Result == testRuntimeError {
+				if err == nil {
+					t.Errorf("[%v] %s: Expected a runtime error, got <%#v>", g, e.Source, v)
+				}
+			} else if err != nil {
+				t.Errorf("[%v] %s: Could not execute: %v", g, e.Source, err)
+			} else if !reflect.DeepEqual(v, e.Result) {
+				t.Errorf("[%v] %s: Expected <%#v>, got <%#v>", g, e.Source, e.Result, v)
+			}
+		}
+	}
+}
+
 func parseAndRun(t *testing.T, source string, context interface{}, result interface{}) {
 
 	s := newScanner(source)
@@ -396,6 +2351,11 @@ func parseAndRun(t *testing.T, source string, context interface{}, result interf
 
 	x.Print(os.Stdout, 0)
 	fmt.Println()
+	x.Print(os.Stdout, PrintOptionOptimized)
+	fmt.Println()
+
+	// every other execution strategy must produce the same result as the program
+	differential(t, source, x, context)
 
 	y, err := x.Exec(context)
 	if err != nil {
@@ -427,3 +2387,47 @@ func parseAndRun(t *testing.T, source string, context interface{}, result interf
 	}
 
 }
+
+func differential(t *testing.T, source string, x *Program, context interface{}) {
+	code, err := compileBytecode(x.root, x.opts)
+	if err != nil {
+		t.Error(fmt.Errorf("[%s] Could not compile bytecode: %v", source, err))
+		return
+	}
+	data, err := code.MarshalBinary()
+	if err != nil {
+		t.Error(fmt.Errorf("[%s] Could not encode bytecode: %v", source, err))
+		return
+	}
+	load, err := LoadBytecode(data)
+	if err != nil {
+		t.Error(fmt.Errorf("[%s] Could not decode bytecode: %v", source, err))
+		return
+	}
+
+	bin, js := &Program{}, &Program{}
+	if data, err = x.MarshalBinary(); err == nil {
+		err = bin.UnmarshalBinary(data)
+	}
+	if err != nil {
+		t.Error(fmt.Errorf("[%s] Could not encode and decode binary: %v", source, err))
+		return
+	}
+	if data, err = x.MarshalJSON(); err == nil {
+		err = js.UnmarshalJSON(data)
+	}
+	if err != nil {
+		t.Error(fmt.Errorf("[%s] Could not encode and decode JSON: %v", source, err))
+		return
+	}
+
+	y, yerr := x.Exec(context)
+	for _, e := range []evaluator{x.tree.exec, x.root.exec, code.exec, load.eval, bin.eval, js.eval} {
+		z, zerr := e(&Runtime{Stdout: os.Stdout}, newContext(stdlib, context))
+		if (yerr != nil) != (zerr != nil) {
+			t.Error(fmt.Errorf("[%s] Execution strategies disagree: %v != %v", source, yerr, zerr))
+		} else if yerr == nil && !reflect.DeepEqual(y, z) {
+			t.Error(fmt.Errorf("[%s] Execution strategies disagree: <%v> (%T) != <%v> (%T)", source, y, y, z, z))
+		}
+	}
+}
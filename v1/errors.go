@@ -31,67 +31,339 @@
 package epl
 
 import (
-  "strconv"
+  "fmt"
+  "errors"
+  "strings"
+  "unicode"
 )
 
-var excerptCallout excerptFormatter
+/**
+ * The kind of an error
+ */
+type ErrorKind int
+
+const (
+  KindSyntax    = ErrorKind(iota) // the source is malformed
+  KindCompile                     // the source is well-formed but cannot be compiled; e.g., a compile limit is exceeded or a function is given the wrong number of arguments
+  KindRuntime                     // evaluation failed
+  KindUndefined                   // a variable, member, or key is not defined
+  KindLimit                       // an execution limit was exceeded; the cause is a *LimitError
+  KindCanceled                    // execution was canceled; the cause is the error of the Go context
+)
 
 /**
- * A span formatter
+ * Kind name
  */
-type excerptFormatter struct {
+func (k ErrorKind) String() string {
+  switch k {
+    case KindSyntax:
+      return "syntax"
+    case KindCompile:
+      return "compile"
+    case KindRuntime:
+      return "runtime"
+    case KindUndefined:
+      return "undefined"
+    case KindLimit:
+      return "limit"
+    case KindCanceled:
+      return "canceled"
+    default:
+      return "unknown"
+  }
 }
 
 /**
- * Format an excerpt
+ * An error produced when compiling or executing a program. The position of
+ * the source which produced the error is described by its line and column
+ * (base 1, the column in characters) and its offset and length (in bytes).
+ * An error which does not refer to a particular position, such as when the
+ * source is too long, has a Line of zero.
  */
-func (f excerptFormatter) FormatExcerpt(s span) string {
-  var e string
-  
-  l := len(s.text)
-  c := "^"
-  
-  // find the beginning of the current line and the line number
-  n := int64(1) // base 1
-  a := s.offset
-  for ; a > 0; a-- {
-    if s.text[a] == '\n' {
-      a++ // right after the newline
-      n++ // newline
-      break
+type Error struct {
+  Kind    ErrorKind
+  Message string
+  Line    int
+  Column  int
+  Offset  int
+  Length  int
+  Cause   error
+  span    span
+}
+
+/**
+ * Create an error
+ */
+func newError(k ErrorKind, s span, cause error, m string) *Error {
+  return &Error{Kind:k, Message:m, Line:s.line, Column:s.column, Offset:s.offset, Length:s.length, Cause:cause, span:s}
+}
+
+/**
+ * Create an error with a formatted message
+ */
+func errorf(k ErrorKind, s span, f string, a ...interface{}) *Error {
+  return newError(k, s, nil, fmt.Sprintf(f, a...))
+}
+
+/**
+ * Obtain the underlying cause, if any
+ */
+func (e *Error) Unwrap() error {
+  return e.Cause
+}
+
+/**
+ * Obtain an excerpt of the source which produced the error with the
+ * relevant span called out, or the empty string if the error has no
+ * position. This is the excerpt included by Error.
+ */
+func (e *Error) Excerpt() string {
+  return e.ExcerptWithOptions(ExcerptOptions{})
+}
+
+/**
+ * Obtain an excerpt of the source which produced the error, formatted
+ * according to the provided options
+ */
+func (e *Error) ExcerptWithOptions(opts ExcerptOptions) string {
+  if e.Line < 1 {
+    return ""
+  }
+  return opts.format(e.span)
+}
+
+/**
+ * Error. This includes the excerpt.
+ */
+func (e *Error) Error() string {
+  m := e.Message
+  if e.Cause != nil {
+    m = fmt.Sprintf("%s: %v", m, e.Cause)
+  }
+  if x := e.Excerpt(); x != "" {
+    m += "\n" + x
+  }
+  return m
+}
+
+/**
+ * A list of errors. Errors in the syntax of a program, and those found when
+ * it is checked, are reported together as an ErrorList, in the order they
+ * occur in the source, so that all of them can be corrected at once. Each
+ * error in the list can be matched with errors.As.
+ */
+type ErrorList []*Error
+
+/**
+ * Error. This describes every error in the list.
+ */
+func (l ErrorList) Error() string {
+  switch len(l) {
+    case 0:
+      return "No errors"
+    case 1:
+      return l[0].Error()
+  }
+  var m string
+  for i, e := range l {
+    if i > 0 {
+      m += "\n"
     }
+    m += e.Error()
+  }
+  return m
+}
+
+/**
+ * Obtain the errors in the list
+ */
+func (l ErrorList) Unwrap() []error {
+  errs := make([]error, len(l))
+  for i, e := range l {
+    errs[i] = e
+  }
+  return errs
+}
+
+/**
+ * Obtain the errors described by an error produced when compiling or
+ * executing a program: the elements of an ErrorList, or the *Error itself.
+ * If err is neither, nil is returned.
+ */
+func Errors(err error) []*Error {
+  var l ErrorList
+  if errors.As(err, &l) {
+    return l
+  }
+  var e *Error
+  if errors.As(err, &e) {
+    return []*Error{e}
+  }
+  return nil
+}
+
+/**
+ * Excerpt options
+ */
+type ExcerptOptions struct {
+  Context   int   // the number of lines to include before and after the line called out
+  TabWidth  int   // the width of a tab stop, in columns; if zero, 4 is used
+  Color     bool  // highlight the excerpt with ANSI escape sequences, for display in a terminal
+}
+
+const defaultTabWidth = 4
+
+const (
+  ansiReset   = "\x1b[0m"
+  ansiGutter  = "\x1b[2m"
+  ansiCallout = "\x1b[1;31m"
+)
+
+/**
+ * Format an excerpt of the text of a span. Each line is prefixed by its
+ * line number and the span is called out beneath the line it begins on,
+ * aligned by the width each character is displayed with.
+ */
+func (o ExcerptOptions) format(s span) string {
+  pos := newPositioner(s.text)
+  line := s.line
+  if line < 1 {
+    line, _ = pos.position(s.offset)
   }
   
-  // count the rest of the newlines
-  for i := a; i > 0; i-- {
-    if s.text[i] == '\n' {
-      n++ // newline
-    }
+  // the range of lines to include
+  first, last := line - o.Context, line + o.Context
+  if first < 1 {
+    first = 1
+  }
+  if n := len(pos.lines); last > n {
+    last = n
   }
   
-  // find the end of the current line
-  z := s.offset
-  for z < l {
-    if s.text[z] == '\n' {
-      break
-    }else{
-      z++
+  g := len(fmt.Sprint(last))
+  b := &strings.Builder{}
+  for n := first; n <= last; n++ {
+    a, z := pos.lines[n-1], len(s.text)
+    if n < len(pos.lines) {
+      z = pos.lines[n] - 1 // exclude the newline
+    }
+    if z > a && s.text[z-1] == '\r' {
+      z--
+    }
+    
+    o.highlight(b, ansiGutter, fmt.Sprintf("%*d: ", g, n))
+    if n != line {
+      o.expand(b, s.text[a:z], 0)
+      b.WriteString("\n")
+      continue
+    }
+    
+    // the part of the span on this line
+    start, end := s.offset, s.offset + s.length
+    if start < a {
+      start = a
+    }else if start > z {
+      start = z
+    }
+    if end > z {
+      end = z
+    }else if end < start {
+      end = start
     }
+    
+    c := o.expand(b, s.text[a:start], 0)
+    x := &strings.Builder{}
+    w := o.expand(x, s.text[start:end], c) - c
+    o.highlight(b, ansiCallout, x.String())
+    o.expand(b, s.text[end:z], c + w)
+    b.WriteString("\n")
+    
+    // add the callout; a span with no width, like the end of input, is
+    // called out all the same
+    if w < 1 {
+      w = 1
+    }
+    b.WriteString(strings.Repeat(" ", g + 2 + c))
+    o.highlight(b, ansiCallout, strings.Repeat("^", w))
+    b.WriteString("\n")
   }
   
-  // add the excerpt line
-  x := strconv.FormatInt(n, 10)
-  e = x +": "+ s.text[a:z] + "\n"
-  lx := len(x) + len(": ")
-  
-  // add the callout
-  p := s.offset - a
-  for i := 0; i < (p + lx); i++ {
-    e += " "
+  return b.String()
+}
+
+/**
+ * Write text which is highlighted by the provided escape sequence when
+ * color is enabled
+ */
+func (o ExcerptOptions) highlight(b *strings.Builder, esc, text string) {
+  if o.Color && text != "" {
+    b.WriteString(esc + text + ansiReset)
+  }else{
+    b.WriteString(text)
   }
-  for i := 0; i < s.length; i++ {
-    e += c
+}
+
+/**
+ * Write text as it is displayed, beginning at the provided column (base 0),
+ * with tabs expanded to spaces. The column after the text is returned.
+ */
+func (o ExcerptOptions) expand(b *strings.Builder, text string, col int) int {
+  tw := o.TabWidth
+  if tw < 1 {
+    tw = defaultTabWidth
   }
-  
-  return e + "\n"
+  for _, r := range text {
+    if r == '\t' {
+      n := tw - (col % tw)
+      b.WriteString(strings.Repeat(" ", n))
+      col += n
+    }else{
+      b.WriteRune(r)
+      col += runeWidth(r)
+    }
+  }
+  return col
+}
+
+/**
+ * The number of columns a character occupies when it is displayed: zero
+ * for control characters and those which combine with the preceding one,
+ * two for wide East Asian characters and emoji, and otherwise one.
+ */
+func runeWidth(r rune) int {
+  switch {
+    case r < 0x20, r >= 0x7f && r < 0xa0:
+      return 0
+    case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+      return 0
+    case unicode.Is(wideRunes, r):
+      return 2
+    default:
+      return 1
+  }
+}
+
+/**
+ * Characters which are displayed two columns wide
+ */
+var wideRunes = &unicode.RangeTable{
+  R16: []unicode.Range16{
+    {0x1100, 0x115f, 1}, // Hangul Jamo
+    {0x2e80, 0x303e, 1}, // CJK radicals, symbols and punctuation
+    {0x3041, 0x33ff, 1}, // Hiragana, Katakana, and CJK compatibility
+    {0x3400, 0x4dbf, 1}, // CJK unified ideographs extension A
+    {0x4e00, 0x9fff, 1}, // CJK unified ideographs
+    {0xa000, 0xa4cf, 1}, // Yi
+    {0xac00, 0xd7a3, 1}, // Hangul syllables
+    {0xf900, 0xfaff, 1}, // CJK compatibility ideographs
+    {0xfe30, 0xfe4f, 1}, // CJK compatibility forms
+    {0xff00, 0xff60, 1}, // fullwidth forms
+    {0xffe0, 0xffe6, 1}, // fullwidth signs
+  },
+  R32: []unicode.Range32{
+    {0x1f300, 0x1f64f, 1}, // pictographs and emoticons
+    {0x1f900, 0x1f9ff, 1}, // supplemental pictographs
+    {0x20000, 0x2fffd, 1}, // CJK unified ideographs extensions
+    {0x30000, 0x3fffd, 1},
+  },
 }
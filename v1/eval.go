@@ -0,0 +1,406 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"math"
+	"reflect"
+)
+
+/**
+ * A schema describes the types of the variables a program will be
+ * executed with. Variables which are not described, or which are described
+ * by an interface type, are not checked.
+ */
+type Schema map[string]reflect.Type
+
+/**
+ * Evaluate a program and convert its result to the type T. Results are
+ * converted as they are when used as operands: a bool accepts booleans and
+ * numbers (which are true when non-zero), a string accepts strings, and a
+ * number accepts any numeric value. An integer type only accepts a number
+ * which it can represent exactly. Any other type accepts a result which is
+ * assignable to it; nil is accepted by types which can be nil.
+ */
+func Eval[T any](p *Program, context interface{}) (T, error) {
+	var z T
+	v, err := p.Exec(context)
+	if err != nil {
+		return z, err
+	}
+	r, err := convertResult(p.span(), v, reflect.TypeOf(&z).Elem())
+	if err != nil {
+		return z, err
+	}
+	// a nil result of an interface type can't be asserted, so it is the zero value
+	if x, ok := r.Interface().(T); ok {
+		return x, nil
+	}
+	return z, nil
+}
+
+/**
+ * Execute and convert the result to a bool
+ */
+func (p *Program) ExecBool(context interface{}) (bool, error) {
+	return Eval[bool](p, context)
+}
+
+/**
+ * Execute and convert the result to a string
+ */
+func (p *Program) ExecString(context interface{}) (string, error) {
+	return Eval[string](p, context)
+}
+
+/**
+ * Execute and convert the result to a float
+ */
+func (p *Program) ExecFloat(context interface{}) (float64, error) {
+	return Eval[float64](p, context)
+}
+
+/**
+ * Obtain the span of the entire program
+ */
+func (p *Program) span() span {
+	if p.tree != nil {
+		return p.tree.src()
+	}
+	return newSpan(p.code.source, 0, len(p.code.source))
+}
+
+/**
+ * Convert a result to the provided type
+ */
+func convertResult(s span, v interface{}, t reflect.Type) (reflect.Value, error) {
+	r := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := asBool(s, v)
+		if err != nil {
+			return r, err
+		}
+		r.SetBool(b)
+		return r, nil
+
+	case reflect.String:
+		c, err := asString(s, v)
+		if err != nil {
+			return r, err
+		}
+		r.SetString(c)
+		return r, nil
+
+	case reflect.Float32, reflect.Float64:
+		f, err := asNumber(s, v)
+		if err != nil {
+			return r, err
+		}
+		if r.OverflowFloat(f) {
+			return r, runtimeErrorf(s, "Cannot cast %v to %v: out of range", f, t)
+		}
+		r.SetFloat(f)
+		return r, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := reflect.ValueOf(v)
+		switch x.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !r.OverflowInt(x.Int()) {
+				r.SetInt(x.Int())
+				return r, nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if x.Uint() <= math.MaxInt64 && !r.OverflowInt(int64(x.Uint())) {
+				r.SetInt(int64(x.Uint()))
+				return r, nil
+			}
+		default:
+			f, err := asNumber(s, v)
+			if err != nil {
+				return r, err
+			}
+			if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !r.OverflowInt(int64(f)) {
+				r.SetInt(int64(f))
+				return r, nil
+			}
+		}
+		return r, runtimeErrorf(s, "Cannot cast %v to %v", v, t)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x := reflect.ValueOf(v)
+		switch x.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if x.Int() >= 0 && !r.OverflowUint(uint64(x.Int())) {
+				r.SetUint(uint64(x.Int()))
+				return r, nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if !r.OverflowUint(x.Uint()) {
+				r.SetUint(x.Uint())
+				return r, nil
+			}
+		default:
+			f, err := asNumber(s, v)
+			if err != nil {
+				return r, err
+			}
+			if f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && !r.OverflowUint(uint64(f)) {
+				r.SetUint(uint64(f))
+				return r, nil
+			}
+		}
+		return r, runtimeErrorf(s, "Cannot cast %v to %v", v, t)
+	}
+
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return r, nil
+		default:
+			return r, runtimeErrorf(s, "Cannot cast <nil> to %v", t)
+		}
+	}
+	x := reflect.ValueOf(v)
+	if !x.Type().AssignableTo(t) {
+		return r, runtimeErrorf(s, "Cannot cast %v to %v", displayType(x), t)
+	}
+	r.Set(x)
+	return r, nil
+}
+
+/**
+ * Determine if a value of type v can be converted to type t, as a result
+ * would be by convertResult
+ */
+func resultConvertible(v, t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool:
+		return v.Kind() == reflect.Bool || isNumericKind(v.Kind())
+	case reflect.String:
+		return v == typeOfString
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return isNumericKind(v.Kind())
+	default:
+		return v.AssignableTo(t)
+	}
+}
+
+/**
+ * Determine if a kind is numeric
+ */
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	typeOfBool    = reflect.TypeOf(false)
+	typeOfString  = reflect.TypeOf("")
+	typeOfFloat64 = reflect.TypeOf(float64(0))
+	typeOfInt64   = reflect.TypeOf(int64(0))
+	typeOfUUID    = reflect.TypeOf(UUID{})
+)
+
+/**
+ * Static type inference, used to check the type of a program's result
+ * when its schema is known. A nil type is unknown.
+ */
+type typeChecker struct {
+	schema Schema
+	env    functionEnv
+}
+
+/**
+ * Check that the result of an expression can be converted to the
+ * provided type
+ */
+func (c typeChecker) checkResult(e executable, t reflect.Type) *Error {
+	v := c.infer(e)
+	if v == nil || resultConvertible(v, t) {
+		return nil
+	}
+	return errorf(KindCompile, e.src(), "Expression produces %v, which cannot be used as %v", v, t)
+}
+
+/**
+ * Infer the type of an expression
+ */
+func (c typeChecker) infer(e executable) reflect.Type {
+	switch n := e.(type) {
+	case *literalNode:
+		if n.value == nil {
+			return nil
+		}
+		return reflect.TypeOf(n.value)
+	case *identNode:
+		return known(c.schema[n.ident])
+	case *uuidNode:
+		if t, ok := c.schema[n.ident]; ok {
+			return known(t)
+		}
+		return typeOfUUID
+	case *logicalOrNode, *logicalAndNode, *relationalNode, *truthNode, *matchNode:
+		return typeOfBool
+	case *arithmeticNode:
+		l := c.infer(n.left)
+		switch {
+		case l == nil:
+			return nil
+		case n.op.which == tokenAdd && l == typeOfString:
+			return typeOfString
+		case !isNumericKind(l.Kind()):
+			return nil
+		case n.op.which == tokenMod:
+			return typeOfInt64
+		default:
+			return typeOfFloat64
+		}
+	case *indexNode:
+		return elem(c.infer(n.left))
+	case *derefNode:
+		return c.member(c.infer(n.left), n.right)
+	case *invokeNode:
+		return c.invoke(nil, n)
+	default:
+		return nil
+	}
+}
+
+/**
+ * Infer the type of a member of a value of type t
+ */
+func (c typeChecker) member(t reflect.Type, e executable) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch n := e.(type) {
+	case *identNode:
+		if t.Kind() == reflect.Map && t.Key() == typeOfString {
+			return known(t.Elem())
+		}
+		b := t
+		for b.Kind() == reflect.Ptr {
+			b = b.Elem()
+		}
+		if b.Kind() != reflect.Struct {
+			return nil
+		}
+		m := typeInfoOf(t).member(n.ident)
+		if m.method >= 0 {
+			f := funcInfoOf(t.Method(m.method).Type)
+			if len(f.out) < 1 || f.out[0] == typeOfError {
+				return nil
+			}
+			return known(f.out[0])
+		}
+		if m.field != nil {
+			return known(b.FieldByIndex(m.field).Type)
+		}
+		return nil
+	case *invokeNode:
+		return c.invoke(t, n)
+	case *indexNode:
+		return elem(c.member(t, n.left))
+	case *derefNode:
+		return c.member(c.member(t, n.left), n.right)
+	default:
+		return nil
+	}
+}
+
+/**
+ * Infer the type of the result of an invocation; recv is the type of the
+ * receiver for a method invocation
+ */
+func (c typeChecker) invoke(recv reflect.Type, n *invokeNode) reflect.Type {
+	ident, ok := n.right.(*identNode)
+	if !ok {
+		return nil
+	}
+
+	var ft reflect.Type
+	if recv != nil {
+		if m, ok := recv.MethodByName(ident.ident); ok {
+			ft = m.Type
+		}
+	} else if f := c.env[ident.ident]; f != nil {
+		ft = reflect.TypeOf(f.Func)
+	} else if v, ok := c.schema[ident.ident]; ok {
+		ft = v
+	} else if f, ok := stdlib[ident.ident]; ok {
+		ft = reflect.TypeOf(f)
+	}
+	if ft == nil || ft.Kind() != reflect.Func {
+		return nil
+	}
+
+	f := funcInfoOf(ft)
+	if len(f.out) < 1 || f.out[0] == typeOfError {
+		return nil
+	}
+	return known(f.out[0])
+}
+
+/**
+ * Infer the type of an element of a value of type t
+ */
+func elem(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return typeOfString
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return known(t.Elem())
+	default:
+		return nil
+	}
+}
+
+/**
+ * Interface types are unknown
+ */
+func known(t reflect.Type) reflect.Type {
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	return t
+}
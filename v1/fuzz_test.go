@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	gocontext "context"
+	"testing"
+)
+
+func FuzzCompile(f *testing.F) {
+	for _, e := range benchSources {
+		f.Add(e.source)
+	}
+	for _, e := range []string{
+		`a.b.c[1]("x", 2) || !(d && e)`,
+		`match("^a+$", s) == true`,
+		`u:0f9a1b2c-3d4e-5f60-7182-93a4b5c6d7e8`,
+		`"é\n" + 'single' + 1.5e3 - 0x1f % 3`,
+		`((((((((((1))))))))))`,
+		`a[`, `f(,)`, `"unterminated`, `1 +`,
+	} {
+		f.Add(e)
+	}
+	opts := CompileOptions{MaxSourceLength: 4096, MaxNodes: 512}
+	f.Fuzz(func(t *testing.T, source string) {
+		for _, g := range []Engine{EngineCompiled, EngineInterpreted, EngineBytecode} {
+			opts.Engine = g
+			p, err := CompileWithOptions(source, opts)
+			if err != nil {
+				continue
+			}
+			// compiled programs must also execute, encode and decode without
+			// panicking, whatever the result
+			p.ExecWithOptions(gocontext.Background(), nil, ExecOptions{MaxSteps: 10000})
+			if data, err := p.MarshalBinary(); err == nil {
+				(&Program{}).UnmarshalBinary(data)
+			}
+			if data, err := p.Bytecode(); err == nil {
+				LoadBytecode(data)
+			}
+		}
+	})
+}
@@ -0,0 +1,382 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+/**
+ * A function parameter
+ */
+type Param struct {
+	Name string
+	Doc  string
+}
+
+/**
+ * A function which can be registered in a library. Func must be a Go
+ * function which follows the conventions of host functions: it may accept
+ * a *State as its first parameter, which is provided by the runtime and is
+ * not an argument, and it may return no values, a value, an error, or a
+ * value and an error.
+ *
+ * A pure function produces a result which depends only on its arguments
+ * and has no side effects. When a pure function is invoked with constant
+ * arguments it may be evaluated when the program is compiled.
+ */
+type Function struct {
+	Name   string
+	Doc    string
+	Params []Param // one for each argument; the last describes the variadic arguments, if any
+	Pure   bool
+	Func   interface{}
+
+	min, max int // the number of arguments accepted; max is -1 if the function is variadic
+}
+
+/**
+ * Obtain the function's signature, as it would be invoked
+ */
+func (f *Function) Signature() string {
+	p := make([]string, len(f.Params))
+	for i, e := range f.Params {
+		if f.max < 0 && i == len(f.Params)-1 {
+			p[i] = "..." + e.Name
+		} else {
+			p[i] = e.Name
+		}
+	}
+	return fmt.Sprintf("%s(%s)", f.Name, strings.Join(p, ", "))
+}
+
+/**
+ * Check the number of arguments provided in an invocation
+ */
+func (f *Function) checkArity(s span, n int) *Error {
+	if f.max < 0 {
+		if n < f.min {
+			return errorf(KindCompile, s, "Function %v takes at least %d arguments but is given %d", f.Signature(), f.min, n)
+		}
+	} else if n != f.max {
+		return errorf(KindCompile, s, "Function %v takes %d arguments but is given %d", f.Signature(), f.max, n)
+	}
+	return nil
+}
+
+/**
+ * A library of functions. Libraries are provided to a program when it is
+ * compiled, via CompileOptions, and their functions are available to it
+ * alongside the standard library. Functions registered in a library take
+ * precedence over variables of the same name in the execution context.
+ */
+type Library struct {
+	Name  string
+	Doc   string
+	funcs map[string]*Function
+	order []*Function
+}
+
+/**
+ * Create a library
+ */
+func NewLibrary(name, doc string) *Library {
+	return &Library{Name: name, Doc: doc, funcs: make(map[string]*Function)}
+}
+
+/**
+ * Register a function
+ */
+func (l *Library) Register(f Function) error {
+	if !isIdentifier(f.Name) {
+		return fmt.Errorf("Invalid function name: %q", f.Name)
+	}
+	if _, ok := l.funcs[f.Name]; ok {
+		return fmt.Errorf("Function %v is already registered in library %v", f.Name, l.Name)
+	}
+
+	v := reflect.ValueOf(f.Func)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("Function %v is not a function: %T", f.Name, f.Func)
+	}
+	ft := funcInfoOf(v.Type())
+
+	switch len(ft.out) {
+	case 0, 1:
+	case 2:
+		if ft.out[1] != typeOfError {
+			return fmt.Errorf("Function %v must return either (void), (interface{}) or (interface{}, error)", f.Name)
+		}
+	default:
+		return fmt.Errorf("Function %v returns %v values (expected: 0, 1 or 2)", f.Name, len(ft.out))
+	}
+
+	n := len(ft.in)
+	if ft.state {
+		n--
+	}
+	if f.Params == nil {
+		f.Params = make([]Param, n)
+		for i := range f.Params {
+			f.Params[i] = Param{Name: fmt.Sprintf("arg%d", i+1)}
+		}
+	} else if len(f.Params) != n {
+		return fmt.Errorf("Function %v has %d parameters but %d are described", f.Name, n, len(f.Params))
+	}
+	if ft.variadic {
+		f.min, f.max = n-1, -1
+	} else {
+		f.min, f.max = n, n
+	}
+
+	if f.Pure {
+		if ft.state {
+			return fmt.Errorf("Function %v is pure and cannot accept %v", f.Name, typeOfState)
+		}
+		if len(ft.out) < 1 || ft.out[0] == typeOfError {
+			return fmt.Errorf("Function %v is pure and must return a value", f.Name)
+		}
+	}
+
+	r := &f
+	l.funcs[f.Name] = r
+	l.order = append(l.order, r)
+	return nil
+}
+
+/**
+ * Register a function or panic. This is intended for libraries which are
+ * defined when a package is initialized.
+ */
+func (l *Library) MustRegister(f Function) *Library {
+	if err := l.Register(f); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+/**
+ * Obtain a function by name, or nil if there is no such function
+ */
+func (l *Library) Function(name string) *Function {
+	return l.funcs[name]
+}
+
+/**
+ * Obtain every function in the library, in the order they were registered
+ */
+func (l *Library) Functions() []*Function {
+	return append([]*Function(nil), l.order...)
+}
+
+/**
+ * Write documentation for the library
+ */
+func (l *Library) Describe(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(l.Name + "\n")
+	if l.Doc != "" {
+		b.WriteString("  " + l.Doc + "\n")
+	}
+	for _, f := range l.order {
+		b.WriteString("\n  " + f.Signature())
+		if f.Pure {
+			b.WriteString(" (pure)")
+		}
+		b.WriteString("\n")
+		if f.Doc != "" {
+			b.WriteString("    " + f.Doc + "\n")
+		}
+		for _, p := range f.Params {
+			if p.Doc != "" {
+				b.WriteString("    " + p.Name + ": " + p.Doc + "\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+/**
+ * Determine if a string is a valid identifier
+ */
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * The functions provided by a set of libraries, by name
+ */
+type functionEnv map[string]*Function
+
+/**
+ * Compose libraries into an environment. A function may not be provided by
+ * more than one library.
+ */
+func newFunctionEnv(libs []*Library) (functionEnv, error) {
+	if len(libs) == 0 {
+		return nil, nil
+	}
+	env := make(functionEnv)
+	from := make(map[string]*Library)
+	for _, l := range libs {
+		for _, f := range l.order {
+			if x, ok := from[f.Name]; ok {
+				return nil, errorf(KindCompile, span{}, "Function %v is registered in both library %v and %v", f.Name, x.Name, l.Name)
+			}
+			env[f.Name], from[f.Name] = f, l
+		}
+	}
+	return env, nil
+}
+
+/**
+ * Produce the context frame for an environment
+ */
+func (e functionEnv) frame() map[string]interface{} {
+	if e == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(e))
+	for k, f := range e {
+		m[k] = f.Func
+	}
+	return m
+}
+
+/**
+ * Determine which of the functions in a context frame an expression tree
+ * refers to, by name, in order
+ */
+func libraryFunctions(x executable, env map[string]interface{}) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var visit func(x executable)
+	visit = func(x executable) {
+		switch n := x.(type) {
+		case *logicalOrNode:
+			visit(n.left)
+			visit(n.right)
+		case *logicalAndNode:
+			visit(n.left)
+			visit(n.right)
+		case *arithmeticNode:
+			visit(n.left)
+			visit(n.right)
+		case *relationalNode:
+			visit(n.left)
+			visit(n.right)
+		case *indexNode:
+			visit(n.left)
+			visit(n.right)
+		case *derefNode:
+			visit(n.left)
+			visit(n.right)
+		case *invokeNode:
+			// the receiver is visited as the left operand of its deref, and a method is not a library function
+			if n.left == nil {
+				visit(n.right)
+			}
+			for _, e := range n.params {
+				visit(e)
+			}
+		case *identNode:
+			if _, ok := env[n.ident]; ok && !n.member && !seen[n.ident] {
+				names = append(names, n.ident)
+				seen[n.ident] = true
+			}
+		}
+	}
+	if len(env) > 0 {
+		visit(x)
+	}
+	return names
+}
+
+/**
+ * Check the arity of invocations of library functions in an expression
+ * tree, producing an error for each invocation which is invalid
+ */
+func (e functionEnv) check(x executable) ErrorList {
+	if len(e) == 0 {
+		return nil
+	}
+	switch n := x.(type) {
+	case *logicalOrNode:
+		return e.checkList(n.left, n.right)
+	case *logicalAndNode:
+		return e.checkList(n.left, n.right)
+	case *arithmeticNode:
+		return e.checkList(n.left, n.right)
+	case *relationalNode:
+		return e.checkList(n.left, n.right)
+	case *indexNode:
+		return e.checkList(n.left, n.right)
+	case *derefNode:
+		return e.checkList(n.left, n.right)
+	case *invokeNode:
+		var errs ErrorList
+		// the receiver of a method is checked as the left operand of its deref
+		if ident, ok := n.right.(*identNode); ok && n.left == nil {
+			if f := e[ident.ident]; f != nil {
+				if err := f.checkArity(n.span, len(n.params)); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		return append(errs, e.checkList(n.params...)...)
+	default:
+		return nil
+	}
+}
+
+/**
+ * Check a list of expressions
+ */
+func (e functionEnv) checkList(l ...executable) ErrorList {
+	var errs ErrorList
+	for _, x := range l {
+		errs = append(errs, e.check(x)...)
+	}
+	return errs
+}
@@ -0,0 +1,194 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+)
+
+/**
+ * Execution options. A zero value for a limit means that it is not enforced.
+ */
+type ExecOptions struct {
+	MaxSteps          int            // the maximum number of nodes evaluated
+	MaxCallDepth      int            // the maximum depth of nested function invocations
+	MaxStringLength   int            // the maximum length of a string produced by concatenation or returned by a function
+	MaxCollectionSize int            // the maximum number of elements in an array, slice, or map returned by a function
+	MaxHostCalls      int            // the maximum number of function and method invocations
+	Stdlib            *StdlibOptions // when non-nil, overrides the builtins available to the program
+	Libraries         []*Library     // when non-nil, overrides the libraries available to the program
+	Stdout            io.Writer      // the destination for output; os.Stdout when nil
+	Clock             Clock          // the source of the current time; the system clock when nil
+	Random            rand.Source    // the source of random values; a shared, randomly seeded source when nil
+	Logger            Logger         // a logger which host functions may use; none when nil
+	DivideByZero      DivisionMode   // the result of division by zero; see DivisionMode
+}
+
+/**
+ * The result of division by zero. By default, as in Go, dividing a number
+ * by zero produces an infinity (or NaN, when it is zero itself). Since the
+ * remainder of integer division by zero cannot be represented, '%' produces
+ * an error unless nil is preferred.
+ */
+type DivisionMode int
+
+const (
+	DivideByZeroInf   DivisionMode = iota // produce +Inf, -Inf, or NaN
+	DivideByZeroError                     // produce a runtime error
+	DivideByZeroNil                       // produce nil
+)
+
+/**
+ * A resource limit. Limits are intended to contain programs written by
+ * untrusted users; when one is exceeded execution stops with an *Error of
+ * KindLimit, the cause of which is a *LimitError.
+ */
+type Limit int
+
+const (
+	LimitSteps Limit = iota
+	LimitCallDepth
+	LimitStringLength
+	LimitCollectionSize
+	LimitHostCalls
+)
+
+/**
+ * Stringer
+ */
+func (l Limit) String() string {
+	switch l {
+	case LimitSteps:
+		return "steps"
+	case LimitCallDepth:
+		return "call depth"
+	case LimitStringLength:
+		return "string length"
+	case LimitCollectionSize:
+		return "collection size"
+	case LimitHostCalls:
+		return "host calls"
+	default:
+		return fmt.Sprintf("Limit(%d)", int(l))
+	}
+}
+
+/**
+ * The cause of the error produced when a resource limit is exceeded. The
+ * error itself is an *Error of KindLimit, which describes the span of
+ * source being evaluated when the limit was reached.
+ */
+type LimitError struct {
+	Limit Limit
+	Max   int
+}
+
+/**
+ * Create the error produced when a limit is exceeded
+ */
+func newLimitError(s span, l Limit, max int) *Error {
+	return newError(KindLimit, s, &LimitError{l, max}, "Execution limit exceeded")
+}
+
+/**
+ * Error
+ */
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v (maximum: %d)", e.Limit, e.Max)
+}
+
+/**
+ * Account for a function invocation. If this succeeds the caller must
+ * invoke leave() when the function returns.
+ */
+func (r *Runtime) enter(s span) error {
+	if r.limits.MaxHostCalls > 0 {
+		r.calls++
+		if r.calls > r.limits.MaxHostCalls {
+			return newLimitError(s, LimitHostCalls, r.limits.MaxHostCalls)
+		}
+	}
+	if r.limits.MaxCallDepth > 0 {
+		if r.depth >= r.limits.MaxCallDepth {
+			return newLimitError(s, LimitCallDepth, r.limits.MaxCallDepth)
+		}
+	}
+	r.depth++
+	return nil
+}
+
+/**
+ * Leave a function invocation
+ */
+func (r *Runtime) leave() {
+	r.depth--
+}
+
+/**
+ * Concatenate strings, subject to the string length limit
+ */
+func (r *Runtime) concat(s span, lv, rv string) (interface{}, error) {
+	if max := r.limits.MaxStringLength; max > 0 && len(lv)+len(rv) > max {
+		return nil, newLimitError(s, LimitStringLength, max)
+	}
+	return lv + rv, nil
+}
+
+/**
+ * Check the size of a value produced by a function against the string
+ * length and collection size limits
+ */
+func (r *Runtime) checkSize(s span, v interface{}) error {
+	if v == nil || (r.limits.MaxStringLength < 1 && r.limits.MaxCollectionSize < 1) {
+		return nil
+	}
+	var l int
+	var lim Limit
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.String:
+		l, lim = rv.Len(), LimitStringLength
+	case reflect.Array, reflect.Slice, reflect.Map:
+		l, lim = rv.Len(), LimitCollectionSize
+	default:
+		return nil
+	}
+	max := r.limits.MaxCollectionSize
+	if lim == LimitStringLength {
+		max = r.limits.MaxStringLength
+	}
+	if max > 0 && l > max {
+		return newLimitError(s, lim, max)
+	}
+	return nil
+}
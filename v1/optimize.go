@@ -0,0 +1,389 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+)
+
+/**
+ * Optimize an expression tree. Subtrees made up entirely of literals are
+ * evaluated once and folded into a single literal, logical operators with
+ * a constant operand are pruned, and regular expressions passed as literals
+ * to the builtin match() are compiled ahead of time. Invocations of pure
+ * library functions in the provided environment with constant arguments
+ * are evaluated.
+ *
+ * Folding is only performed when evaluation succeeds; an expression which
+ * would fail (e.g., `"A" + 100`) is left in place so that it produces the
+ * same runtime error it always did.
+ */
+func optimize(e executable, env functionEnv) executable {
+	switch n := e.(type) {
+
+	case *logicalOrNode:
+		left, right := optimize(n.left, env), optimize(n.right, env)
+		if v, ok := constantBool(left); ok {
+			if v {
+				return &literalNode{node{n.span, nil}, true}
+			} else {
+				return optimizeTruth(n.span, right)
+			}
+		}
+		if v, ok := constantBool(right); ok && !v {
+			return optimizeTruth(n.span, left)
+		}
+		return &logicalOrNode{n.node, left, right}
+
+	case *logicalAndNode:
+		left, right := optimize(n.left, env), optimize(n.right, env)
+		if v, ok := constantBool(left); ok {
+			if !v {
+				return &literalNode{node{n.span, nil}, false}
+			} else {
+				return optimizeTruth(n.span, right)
+			}
+		}
+		if v, ok := constantBool(right); ok && v {
+			return optimizeTruth(n.span, left)
+		}
+		return &logicalAndNode{n.node, left, right}
+
+	case *arithmeticNode:
+		return fold(&arithmeticNode{n.node, n.op, optimize(n.left, env), optimize(n.right, env)})
+
+	case *relationalNode:
+		return fold(&relationalNode{n.node, n.op, optimize(n.left, env), optimize(n.right, env)})
+
+	case *indexNode:
+		return fold(&indexNode{n.node, optimize(n.left, env), optimize(n.right, env)})
+
+	case *derefNode:
+		return &derefNode{n.node, optimize(n.left, env), optimizeMember(n.right, env)}
+
+	case *invokeNode:
+		return optimizeInvoke(n, env)
+
+	default:
+		return e
+
+	}
+}
+
+/**
+ * Optimize the right operand of a dereference. The operand is evaluated
+ * against the dereferenced value, so its own node type must be preserved;
+ * only its subexpressions are optimized.
+ */
+func optimizeMember(e executable, env functionEnv) executable {
+	switch n := e.(type) {
+	case *derefNode:
+		return &derefNode{n.node, optimizeMember(n.left, env), optimizeMember(n.right, env)}
+	case *indexNode:
+		return &indexNode{n.node, optimizeMember(n.left, env), optimize(n.right, env)}
+	case *invokeNode:
+		return &invokeNode{n.node, n.left, n.right, optimizeList(n.params, env)}
+	default:
+		return e
+	}
+}
+
+/**
+ * Optimize a function invocation
+ */
+func optimizeInvoke(n *invokeNode, env functionEnv) executable {
+	var left executable
+	if n.left != nil {
+		left = optimize(n.left, env)
+	}
+
+	x := &invokeNode{n.node, left, n.right, optimizeList(n.params, env)}
+	if left != nil {
+		return x
+	}
+
+	ident, ok := x.right.(*identNode)
+	if !ok {
+		return x
+	}
+
+	// library functions take precedence over builtins
+	if f := env[ident.ident]; f != nil {
+		if f.Pure {
+			return foldPure(x, f)
+		}
+		return x
+	}
+
+	switch ident.ident {
+	case "match":
+		if len(x.params) != 2 {
+			return x
+		}
+		lit, ok := x.params[0].(*literalNode)
+		if !ok {
+			return x
+		}
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return x
+		}
+		expr, err := regexes.compile(pattern)
+		if err != nil {
+			return x // not the builtin, since its patterns are checked when the program is created
+		}
+		return &matchNode{*x, expr}
+	}
+
+	return x
+}
+
+/**
+ * Optimize a list of expressions
+ */
+func optimizeList(l []executable, env functionEnv) []executable {
+	if l == nil {
+		return nil
+	}
+	o := make([]executable, len(l))
+	for i, e := range l {
+		o[i] = optimize(e, env)
+	}
+	return o
+}
+
+/**
+ * Produce the boolean value of an expression, as a logical operator would
+ */
+func optimizeTruth(s span, e executable) executable {
+	if v, ok := constantBool(e); ok {
+		return &literalNode{node{s, nil}, v}
+	}
+	return &truthNode{node{s, nil}, e}
+}
+
+/**
+ * Obtain the boolean value of a constant expression, if it has one
+ */
+func constantBool(e executable) (bool, bool) {
+	lit, ok := e.(*literalNode)
+	if !ok {
+		return false, false
+	}
+	v, err := asBool(lit.src(), lit.value)
+	if err != nil {
+		return false, false
+	}
+	return v, true
+}
+
+/**
+ * Fold a node into a literal if all of its operands are literals and it
+ * can be evaluated without error.
+ */
+func fold(e executable) executable {
+	var operands []executable
+	switch n := e.(type) {
+	case *arithmeticNode:
+		operands = []executable{n.left, n.right}
+	case *relationalNode:
+		operands = []executable{n.left, n.right}
+	case *indexNode:
+		operands = []executable{n.left, n.right}
+	default:
+		return e
+	}
+	for _, o := range operands {
+		if _, ok := o.(*literalNode); !ok {
+			return e
+		}
+	}
+	// division by zero is left to be evaluated at runtime, where its result
+	// depends on how the program is executed
+	v, err := e.exec(&Runtime{limits: ExecOptions{DivideByZero: DivideByZeroError}}, newContext())
+	if err != nil {
+		return e
+	}
+	return &literalNode{node{e.src(), nil}, v}
+}
+
+/**
+ * Fold an invocation of a pure library function into a literal if all of
+ * its arguments are literals, it can be evaluated without error, and its
+ * result can be represented as a literal.
+ */
+func foldPure(n *invokeNode, f *Function) executable {
+	for _, o := range n.params {
+		if _, ok := o.(*literalNode); !ok {
+			return n
+		}
+	}
+	v, err := callFunction(&Runtime{}, newContext(), n.span, reflect.ValueOf(f.Func), f.Name, n.params)
+	if err != nil {
+		return n
+	}
+	switch v.(type) {
+	case nil, bool, float64, int64, string:
+		return &literalNode{node{n.span, nil}, v}
+	default:
+		return n
+	}
+}
+
+/**
+ * A node which coerces the result of an expression to a boolean. This is
+ * produced when a logical operator is pruned but the value of the remaining
+ * operand must still be converted.
+ */
+type truthNode struct {
+	node
+	expr executable
+}
+
+/**
+ * Execute
+ */
+func (n *truthNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
+	v, err := n.expr.exec(runtime, context)
+	if err != nil {
+		return nil, err
+	}
+	return asBool(n.expr.src(), v)
+}
+
+/**
+ * Print
+ */
+func (n *truthNode) print(w io.Writer, opts PrintOptions, state printState) error {
+	indent := state.Indent()
+
+	_, err := w.Write([]byte(indent + fmt.Sprintf("%T (\n", n)))
+	if err != nil {
+		return err
+	}
+
+	n.expr.print(w, opts, state.Desc())
+
+	_, err = w.Write([]byte("\n" + indent + ")\n"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/**
+ * An invocation of the builtin match() with a precompiled pattern. Since
+ * the name 'match' may be shadowed by the context, the function is still
+ * resolved at runtime and the general invocation is used if it is not the
+ * builtin.
+ */
+type matchNode struct {
+	invokeNode
+	expr *regexp.Regexp
+}
+
+var builtInMatchPointer = reflect.ValueOf(builtInMatch).Pointer()
+
+/**
+ * Execute
+ */
+func (n *matchNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if !isBuiltInMatch(runtime, context, n.span) {
+		return n.invokeNode.exec(runtime, context)
+	}
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
+	v, err := n.params[1].exec(runtime, context)
+	if err != nil {
+		return nil, err
+	}
+	return matchPrecompiled(n.span, n.expr, v)
+}
+
+/**
+ * Determine if 'match' refers to the builtin in the provided context
+ */
+func isBuiltInMatch(runtime *Runtime, context *context, s span) bool {
+	f, err := context.value(runtime, s, "match")
+	if err != nil || f == nil {
+		return false
+	}
+	v := reflect.ValueOf(f)
+	return v.Kind() == reflect.Func && v.Pointer() == builtInMatchPointer
+}
+
+/**
+ * Match a precompiled expression against an evaluated operand
+ */
+func matchPrecompiled(s span, expr *regexp.Regexp, v interface{}) (interface{}, error) {
+	t, ok := v.(string)
+	if !ok {
+		return false, functionError(s, "match", fmt.Errorf("Invalid parameter to: match(string, string)"))
+	}
+	return expr.MatchString(t), nil
+}
+
+/**
+ * Print
+ */
+func (n *matchNode) print(w io.Writer, opts PrintOptions, state printState) error {
+	indent := state.Indent()
+
+	_, err := w.Write([]byte(indent + fmt.Sprintf("%T (\n", n) + indent + indentLevel + "regexp:" + n.expr.String() + "\n"))
+	if err != nil {
+		return err
+	}
+
+	for i, p := range n.params[1:] {
+		if i > 0 {
+			_, err = w.Write([]byte(indent + ",\n"))
+			if err != nil {
+				return err
+			}
+		}
+		p.print(w, opts, state.Desc())
+	}
+
+	_, err = w.Write([]byte("\n" + indent + ")\n"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
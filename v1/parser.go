@@ -40,13 +40,51 @@ import (
 type parser struct {
   scanner   *scanner
   la        []token
+  opts      CompileOptions
+  depth     int
+  nodes     int
+  errs      ErrorList
 }
 
 /**
  * Create a parser
  */
 func newParser(s *scanner) *parser {
-  return &parser{s, make([]token, 0, 2)}
+  return &parser{s, make([]token, 0, 2), CompileOptions{}, 0, 0, nil}
+}
+
+/**
+ * Enter a level of nesting, described by the provided span. If this
+ * succeeds the caller must invoke leave() when the level is complete.
+ */
+func (p *parser) enter(s span) error {
+  max := p.opts.MaxDepth
+  if max < 1 {
+    max = DefaultMaxDepth
+  }
+  if p.depth >= max {
+    return errorf(KindCompile, s, "Expression is nested too deeply (maximum depth: %d)", max)
+  }
+  p.depth++
+  return nil
+}
+
+/**
+ * Leave a level of nesting
+ */
+func (p *parser) leave() {
+  p.depth--
+}
+
+/**
+ * Account for a node, described by the provided span
+ */
+func (p *parser) node(s span) error {
+  p.nodes++
+  if max := p.opts.MaxNodes; max > 0 && p.nodes > max {
+    return errorf(KindCompile, s, "Expression has too many nodes (maximum: %d)", max)
+  }
+  return nil
 }
 
 /**
@@ -87,22 +125,92 @@ func (p *parser) next() token {
 }
 
 /**
- * Consume the next token asserting that it is one of the provided token types
+ * Consume the next token, which is expected to be of the provided type. If
+ * it is not, the error is recorded and the parser synchronizes; the token
+ * it stops at is consumed if it is the one expected.
  */
-func (p *parser) nextAssert(valid ...tokenType) (token, error) {
-  t := p.next()
+func (p *parser) expect(which tokenType) (token, error) {
+  t := p.peek(0)
+  if t.which == which {
+    return p.next(), nil
+  }
+  var err error
   switch t.which {
-    case tokenEOF:
-      return token{}, fmt.Errorf("Unexpected end-of-input")
-    case tokenError:
-      return token{}, fmt.Errorf("Error: %v", t)
+    case tokenEOF, tokenError:
+      err = errorForToken(t)
+    default:
+      err = invalidTokenError(t, which)
   }
-  for _, v := range valid {
-    if t.which == v {
-      return t, nil
+  if err := p.recover(err); err != nil {
+    return token{}, err
+  }
+  if t = p.peek(0); t.which == which {
+    return p.next(), nil
+  }
+  return t, nil
+}
+
+/**
+ * Record a syntax error so that parsing can continue and any further errors
+ * can be reported along with it. Only the first error at a given position is
+ * recorded. Errors which are not syntax errors, like those produced when a
+ * compile limit is exceeded, cannot be recovered from and are returned.
+ */
+func (p *parser) record(err error) error {
+  e, ok := err.(*Error)
+  if !ok || e.Kind != KindSyntax {
+    return err
+  }
+  for _, x := range p.errs {
+    if x.Offset == e.Offset {
+      return nil
     }
   }
-  return token{}, invalidTokenError(t, valid...)
+  p.errs = append(p.errs, e)
+  return nil
+}
+
+/**
+ * Record a syntax error and synchronize the parser by skipping input until
+ * the next token which can delimit an expression: ')', ']', ',', '&&', or
+ * '||', outside of any group the skipped input opens. That token is not
+ * consumed, so the parser resumes from it.
+ */
+func (p *parser) recover(err error) error {
+  if err := p.record(err); err != nil {
+    return err
+  }
+  depth := 0
+  for {
+    switch t := p.peek(0); t.which {
+      case tokenEOF:
+        return nil
+      case tokenLParen, tokenLBracket:
+        depth++
+      case tokenRParen, tokenRBracket:
+        if depth == 0 {
+          return nil
+        }
+        depth--
+      case tokenComma, tokenLogicalAnd, tokenLogicalOr:
+        if depth == 0 {
+          return nil
+        }
+    }
+    p.next()
+  }
+}
+
+/**
+ * Record a syntax error in place of an expression and produce a placeholder
+ * for it, so that parsing can continue. The placeholder is never executed,
+ * since a program is not produced when errors have been recorded.
+ */
+func (p *parser) invalid(t token, err error) (executable, error) {
+  if err := p.recover(err); err != nil {
+    return nil, err
+  }
+  return &identNode{node{t.span, &t}, "", false}, nil
 }
 
 /**
@@ -110,19 +218,44 @@ func (p *parser) nextAssert(valid ...tokenType) (token, error) {
  */
 func (p *parser) parse() (*Program, error) {
   e, err := p.parseExpression()
+  for err == nil {
+    t := p.peek(0)
+    if t.which == tokenEOF {
+      break
+    }
+    // the input continues past the end of the expression; if it continues
+    // with another operand, that is parsed too so its errors are reported
+    p.next()
+    if err = p.recover(errorf(KindSyntax, t.span, "Syntax error: %v", t)); err != nil {
+      break
+    }
+    switch p.peek(0).which {
+      case tokenComma, tokenLogicalAnd, tokenLogicalOr:
+        p.next()
+        _, err = p.parseExpression()
+    }
+  }
   if err != nil {
-    return nil, err
-  }else if t := p.peek(0); t.which != tokenEOF {
-    return nil, fmt.Errorf("Syntax error: %v", t)
-  }else{
-    return &Program{e}, nil
+    x, ok := err.(*Error)
+    if !ok {
+      return nil, err
+    }
+    p.errs = append(p.errs, x)
+  }
+  if len(p.errs) > 0 {
+    return nil, p.errs
   }
+  return newProgram(e, p.opts)
 }
 
 /**
  * Parse
  */
 func (p *parser) parseExpression() (executable, error) {
+  if err := p.enter(p.peek(0).span); err != nil {
+    return nil, err
+  }
+  defer p.leave()
   return p.parseLogicalOr()
 }
 
@@ -139,7 +272,8 @@ func (p *parser) parseLogicalOr() (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenLogicalOr:
       break // valid token
     default:
@@ -147,7 +281,14 @@ func (p *parser) parseLogicalOr() (executable, error) {
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseLogicalOr()
+  p.leave()
   if err != nil {
     return nil, err
   }
@@ -168,7 +309,8 @@ func (p *parser) parseLogicalAnd() (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenLogicalAnd:
       break // valid token
     default:
@@ -176,7 +318,14 @@ func (p *parser) parseLogicalAnd() (executable, error) {
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseLogicalAnd()
+  p.leave()
   if err != nil {
     return nil, err
   }
@@ -197,15 +346,23 @@ func (p *parser) parseRelational() (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
-    case tokenLess, tokenGreater, tokenEqual, tokenLessEqual, tokenGreaterEqual, tokenNotEqual:
+      p.next()
+      return left, p.recover(errorForToken(op))
+    case tokenLess, tokenGreater, tokenEqual, tokenLessEqual, tokenGreaterEqual, tokenNotEqual, tokenIn:
       break // valid tokens
     default:
       return left, nil
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseRelational()
+  p.leave()
   if err != nil {
     return nil, err
   }
@@ -226,7 +383,8 @@ func (p *parser) parseArithmeticL1() (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenAdd, tokenSub:
       break // valid tokens
     default:
@@ -234,7 +392,14 @@ func (p *parser) parseArithmeticL1() (executable, error) {
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseArithmeticL1()
+  p.leave()
   if err != nil {
     return nil, err
   }
@@ -255,7 +420,8 @@ func (p *parser) parseArithmeticL2() (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenMul, tokenDiv, tokenMod:
       break // valid tokens
     default:
@@ -263,7 +429,14 @@ func (p *parser) parseArithmeticL2() (executable, error) {
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseArithmeticL2()
+  p.leave()
   if err != nil {
     return nil, err
   }
@@ -284,7 +457,8 @@ func (p *parser) parseDeref(prev executable) (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenDot:
       break // valid token
     default:
@@ -292,16 +466,27 @@ func (p *parser) parseDeref(prev executable) (executable, error) {
   }
   
   p.next() // consume the operator
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseDeref(left)
+  p.leave()
   if err != nil {
     return nil, err
   }
   
   switch v := right.(type) {
+    case *uuidNode: // a property named by a UUID identifier
+      markMember(&v.identNode)
+      return &derefNode{node{encompass(op.span, left.src()), &op}, left, &v.identNode}, nil
     case *identNode, *derefNode, *indexNode, *invokeNode:
+      markMember(v)
       return &derefNode{node{encompass(op.span, left.src()), &op}, left, v}, nil
     default:
-      return nil, fmt.Errorf("Expected ident, deref or subscript: (%T) %v\n%v", right, right, excerptCallout.FormatExcerpt(right.src()))
+      return left, p.recover(errorf(KindSyntax, right.src(), "Expected ident, deref or subscript: (%T) %v", right, right))
   }
   
 }
@@ -319,7 +504,8 @@ func (p *parser) parseInvoke(left executable) (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenLParen:
       break // valid token
     default:
@@ -327,12 +513,15 @@ func (p *parser) parseInvoke(left executable) (executable, error) {
   }
   
   p.next() // consume the '('
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
   params, err := p.parseExprList()
   if err != nil {
     return nil, err
   }
   
-  t, err := p.nextAssert(tokenRParen)
+  t, err := p.expect(tokenRParen)
   if err != nil {
     return nil, err
   }
@@ -365,7 +554,8 @@ func (p *parser) parseSubscript(left executable) (executable, error) {
   op := p.peek(0)
   switch op.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", op)
+      p.next()
+      return left, p.recover(errorForToken(op))
     case tokenLBracket:
       break // valid token
     default:
@@ -373,16 +563,23 @@ func (p *parser) parseSubscript(left executable) (executable, error) {
   }
   
   p.next() // consume the '['
+  if err := p.node(op.span); err != nil {
+    return nil, err
+  }
   right, err := p.parseExpression()
   if err != nil {
     return nil, err
   }
   
-  t, err := p.nextAssert(tokenRBracket)
+  t, err := p.expect(tokenRBracket)
   if err != nil {
     return nil, err
   }
   
+  if err := p.enter(op.span); err != nil {
+    return nil, err
+  }
+  defer p.leave()
   return p.parseSubscript(&indexNode{node{encompass(op.span, left.src(), right.src(), t.span), &op}, left, right})
 }
 
@@ -390,16 +587,41 @@ func (p *parser) parseSubscript(left executable) (executable, error) {
  * Parse a primary expression
  */
 func (p *parser) parsePrimary() (executable, error) {
-  t := p.next()
+  t := p.peek(0)
   switch t.which {
     case tokenEOF:
-      return nil, fmt.Errorf("Unexpected end-of-input")
+      return p.invalid(t, errorForToken(t))
+    case tokenRParen, tokenRBracket, tokenComma, tokenLogicalAnd, tokenLogicalOr:
+      // not consumed, since this is where the parser would synchronize
+      return p.invalid(t, errorf(KindSyntax, t.span, "Illegal token in primary expression: %v", t))
+  }
+  p.next()
+  switch t.which {
+    case tokenError, tokenLParen:
+      // not a node
+    default:
+      if err := p.node(t.span); err != nil {
+        return nil, err
+      }
+  }
+  switch t.which {
     case tokenError:
-      return nil, fmt.Errorf("Error: %v", t)
+      return p.invalid(t, errorForToken(t))
     case tokenLParen:
       return p.parseParen()
     case tokenIdentifier:
-      return &identNode{node{t.span, &t}, t.value.(string)}, nil
+      v := t.value.(string)
+      if max := p.opts.MaxIdentifierLength; max > 0 && len(v) > max {
+        return nil, errorf(KindCompile, t.span, "Identifier is too long: %d bytes (maximum: %d)", len(v), max)
+      }
+      return &identNode{node{t.span, &t}, v, false}, nil
+    case tokenUUID:
+      v := t.value.(string)
+      u, err := uuidFromIdent(v)
+      if err != nil {
+        return p.invalid(t, errorf(KindSyntax, t.span, "UUID identifier is incorrectly formatted"))
+      }
+      return &uuidNode{identNode{node{t.span, &t}, v, false}, u}, nil
     case tokenNumber, tokenString:
       return &literalNode{node{t.span, &t}, t.value}, nil
     case tokenTrue:
@@ -409,7 +631,7 @@ func (p *parser) parsePrimary() (executable, error) {
     case tokenNil:
       return &literalNode{node{t.span, &t}, nil}, nil
     default:
-      return nil, fmt.Errorf("Illegal token in primary expression: %v", t)
+      return p.invalid(t, errorf(KindSyntax, t.span, "Illegal token in primary expression: %v", t))
   }
 }
 
@@ -423,9 +645,8 @@ func (p *parser) parseParen() (executable, error) {
     return nil, err
   }
   
-  t := p.next()
-  if t.which != tokenRParen {
-    return nil, fmt.Errorf("Expected ')' but found %v", t)
+  if _, err := p.expect(tokenRParen); err != nil {
+    return nil, err
   }
   
   return e, nil
@@ -450,37 +671,40 @@ func (p *parser) parseExprList() ([]executable, error) {
     }
     
     list = append(list, expr)
-    
     t = p.peek(0)
-    if t.which == tokenComma {
-      p.next() // consume the comma
-    }else{
-      break
+    switch t.which {
+      case tokenRParen, tokenEOF, tokenError:
+        return list, nil // end of list; the caller expects ')'
+      case tokenComma:
+        // valid token
+      default:
+        // skip the invalid input; if it ends at a comma, the list continues
+        if err := p.recover(invalidTokenError(t, tokenComma, tokenRParen)); err != nil {
+          return nil, err
+        }
+        if p.peek(0).which != tokenComma {
+          return list, nil
+        }
     }
     
+    p.next() // consume the comma
   }
-  
-  return list, nil
-}
-
-/**
- * A parser error
- */
-type parserError struct {
-  message   string
-  span      span
-  cause     error
 }
 
 /**
- * Error
+ * Produce the error for a token. An error token carries the error produced
+ * by the scanner.
  */
-func (e parserError) Error() string {
-  if e.cause != nil {
-    return fmt.Sprintf("%s: %v\n%v", e.message, e.cause, excerptCallout.FormatExcerpt(e.span))
-  }else{
-    return fmt.Sprintf("%s\n%v", e.message, excerptCallout.FormatExcerpt(e.span))
+func errorForToken(t token) error {
+  switch t.which {
+    case tokenEOF:
+      return newError(KindSyntax, t.span, nil, "Unexpected end-of-input")
+    case tokenError:
+      if err, ok := t.value.(*Error); ok {
+        return err
+      }
   }
+  return errorf(KindSyntax, t.span, "Error: %v", t)
 }
 
 /**
@@ -498,5 +722,5 @@ func invalidTokenError(t token, e ...tokenType) error {
     m += ")"
   }
   
-  return &parserError{m, t.span, nil}
+  return newError(KindSyntax, t.span, nil, m)
 }
@@ -32,10 +32,18 @@ package epl
 
 import (
 	"bytes"
+	gocontext "context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/netip"
 	"os"
 	"reflect"
+	"strings"
+	"time"
 )
 
 var undefinedVariableError = fmt.Errorf("undefined")
@@ -53,10 +61,69 @@ type Context interface {
 type VariableProvider func(name string) (interface{}, error)
 
 /**
- * Executable context
+ * Executable context. The Go context under which a program is executed and
+ * the facilities provided by ExecOptions are available to host functions
+ * via the Runtime field of State.
  */
 type Runtime struct {
-	Stdout io.Writer
+	Stdout  io.Writer
+	Context gocontext.Context
+	Clock   Clock
+	Random  *rand.Rand
+	Logger  Logger // may be nil
+	done    <-chan struct{}
+	limits  ExecOptions
+	steps   int
+	calls   int
+	depth   int
+}
+
+/**
+ * Create a runtime
+ */
+func newRuntime(cxt gocontext.Context, opts ExecOptions) *Runtime {
+	r := &Runtime{
+		Stdout:  opts.Stdout,
+		Context: cxt,
+		Clock:   opts.Clock,
+		Logger:  opts.Logger,
+		done:    cxt.Done(),
+		limits:  opts,
+	}
+	if r.Stdout == nil {
+		r.Stdout = os.Stdout
+	}
+	if r.Clock == nil {
+		r.Clock = systemClock{}
+	}
+	if opts.Random != nil {
+		r.Random = rand.New(opts.Random)
+	} else {
+		r.Random = defaultRandom
+	}
+	return r
+}
+
+/**
+ * Account for the evaluation of a node, which is described by the provided
+ * span. This produces an error if execution has been canceled or the step
+ * limit has been exceeded.
+ */
+func (r *Runtime) step(s span) error {
+	if r.limits.MaxSteps > 0 {
+		r.steps++
+		if r.steps > r.limits.MaxSteps {
+			return newLimitError(s, LimitSteps, r.limits.MaxSteps)
+		}
+	}
+	if r.done != nil {
+		select {
+		case <-r.done:
+			return newError(KindCanceled, s, r.Context.Err(), "Execution canceled")
+		default:
+		}
+	}
+	return nil
 }
 
 /**
@@ -111,28 +178,70 @@ func (c *context) top() interface{} {
  * Obtain a value
  */
 func (c *context) get(r *Runtime, s span, n string) (interface{}, error) {
-	return c.sget(r, s, n, c.stack, derefOptionDerefFunctions)
+	v, err := c.sget(r, s, n, c.stack, derefOptionDerefFunctions, nil)
+	return resolved(s, n, v, err)
+}
+
+/**
+ * Obtain a value, using the provided cache to resolve struct members
+ */
+func (c *context) lookup(r *Runtime, s span, n string, m *memberCache) (interface{}, error) {
+	v, err := c.sget(r, s, n, c.stack, derefOptionDerefFunctions, m)
+	return resolved(s, n, v, err)
+}
+
+/**
+ * Obtain a member of a dereferenced value, using the provided cache, if
+ * any, to resolve struct members. Frames of functions are not searched, so
+ * a member which is not defined is never resolved to a builtin.
+ */
+func (c *context) member(r *Runtime, s span, n string, m *memberCache) (interface{}, error) {
+	v, err := c.sget(r, s, n, c.stack, derefOptionDerefFunctions|derefOptionMember, m)
+	return resolved(s, n, v, err)
 }
 
 /**
  * Obtain a value, without dereferencing
  */
 func (c *context) value(r *Runtime, s span, n string) (interface{}, error) {
-	return c.sget(r, s, n, c.stack, 0)
+	v, err := c.sget(r, s, n, c.stack, 0, nil)
+	return resolved(s, n, v, err)
+}
+
+/**
+ * Convert the result of resolving the name n, described by the span s, so
+ * that any error refers to it
+ */
+func resolved(s span, n string, v interface{}, err error) (interface{}, error) {
+	if err == nil {
+		return v, nil
+	} else if err == undefinedVariableError {
+		return nil, errorf(KindUndefined, s, "Undefined variable '%v'", n)
+	} else if _, ok := err.(*Error); ok {
+		return nil, err
+	} else {
+		return nil, newError(KindRuntime, s, err, fmt.Sprintf("Could not resolve '%v'", n))
+	}
 }
 
 /**
  * Obtain a value
  */
-func (c *context) sget(r *Runtime, s span, n string, k []interface{}, opts derefOptions) (interface{}, error) {
+func (c *context) sget(r *Runtime, s span, n string, k []interface{}, opts derefOptions, m *memberCache) (interface{}, error) {
 	l := len(k)
 	if l < 1 {
 		return nil, nil
 	}
 
-	v, err := derefProp(r, c, s, k[l-1], n, opts)
+	var v interface{}
+	var err error
+	if _, ok := k[l-1].(functionFrame); ok && (opts&derefOptionMember) == derefOptionMember {
+		err = undefinedVariableError
+	} else {
+		v, err = derefProp(r, c, s, k[l-1], n, opts, m)
+	}
 	if err == undefinedVariableError && l > 1 {
-		return c.sget(r, s, n, k[:l-1], opts)
+		return c.sget(r, s, n, k[:l-1], opts, m)
 	} else if err != nil {
 		return nil, err
 	}
@@ -140,6 +249,12 @@ func (c *context) sget(r *Runtime, s span, n string, k []interface{}, opts deref
 	return v, nil
 }
 
+/**
+ * A frame of builtin or library functions. Its functions are resolved by
+ * bare identifiers and invocations, but never as the member of a value.
+ */
+type functionFrame map[string]interface{}
+
 /**
  * Execution state
  */
@@ -158,7 +273,8 @@ var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
 type PrintOptions int
 
 const (
-	PrintOptionNone = 0
+	PrintOptionNone      = 0
+	PrintOptionOptimized = PrintOptions(1 << 0)
 )
 
 /**
@@ -279,27 +395,184 @@ func (n *node) print(w io.Writer, opts PrintOptions, state printState) error {
  * A program
  */
 type Program struct {
-	root executable
+	opts CompileOptions
+	lib  map[string]interface{} // the standard library, as configured
+	env  map[string]interface{} // the functions provided by libraries, if any
+	tree executable             // as parsed
+	root executable             // as optimized
+	code *bytecode              // as compiled to bytecode, if the bytecode engine is used
+	eval evaluator              // as prepared for the selected engine, which is what is executed
+	uses []string               // the library functions a decoded program requires, which were not encoded
+}
+
+/**
+ * Create a program from the parsed expression tree
+ */
+func newProgram(tree executable, opts CompileOptions) (*Program, error) {
+	lib, err := opts.Stdlib.library()
+	if err != nil {
+		return nil, err
+	}
+	env, err := newFunctionEnv(opts.Libraries)
+	if err != nil {
+		return nil, err
+	}
+	errs := env.check(tree)
+	errs = append(errs, checkPatterns(tree, lib, env)...)
+	if opts.Result != nil {
+		if err := (typeChecker{opts.Schema, env}).checkResult(tree, opts.Result); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	p := &Program{opts: opts, lib: lib, env: env.frame(), tree: tree, root: optimize(tree, env)}
+	switch opts.Engine {
+	case EngineCompiled:
+		p.eval = compile(p.root)
+	case EngineInterpreted:
+		p.eval = p.root.exec
+	case EngineBytecode:
+		code, err := compileBytecode(p.root, opts)
+		if err != nil {
+			return nil, err
+		}
+		p.code, p.eval = code, code.exec
+	default:
+		return nil, fmt.Errorf("Unsupported engine: %v", opts.Engine)
+	}
+	return p, nil
+}
+
+/**
+ * Load a program from bytecode previously produced by Program.Bytecode.
+ * The program is not parsed again and its expression tree is not
+ * available, so it cannot be printed. The builtins available to it are
+ * those it was compiled with. Libraries are not encoded, so a program which
+ * uses library functions fails unless they are provided when it is executed
+ * by ExecOptions.Libraries. The Schema and Result are not encoded either;
+ * they are only used to check the program when it is compiled.
+ */
+func LoadBytecode(data []byte) (*Program, error) {
+	code := &bytecode{}
+	err := code.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	lib, err := code.opts.Stdlib.library()
+	if err != nil {
+		return nil, err
+	}
+	return &Program{opts: code.opts, lib: lib, code: code, eval: code.exec, uses: code.uses}, nil
+}
+
+/**
+ * Determine which library functions the program requires. A program which
+ * was decoded doesn't have its libraries, only the names it requires.
+ */
+func (p *Program) functions() []string {
+	if p.env == nil {
+		return p.uses
+	}
+	return libraryFunctions(p.tree, p.env)
+}
+
+/**
+ * Obtain the program's bytecode in a form that can be loaded by
+ * LoadBytecode. The program need not have been compiled for the bytecode
+ * engine.
+ */
+func (p *Program) Bytecode() ([]byte, error) {
+	code := p.code
+	if code == nil {
+		var err error
+		code, err = compileBytecode(p.root, p.opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := *code
+	c.uses = p.functions()
+	return c.MarshalBinary()
 }
 
 /**
  * Execute
  */
 func (p *Program) Exec(context interface{}) (interface{}, error) {
-	return p.root.exec(&Runtime{os.Stdout}, newContext(stdlib, context))
+	return p.ExecContext(gocontext.Background(), context)
 }
 
 /**
- * Print
+ * Execute under the provided Go context. Evaluation stops with an error
+ * when the context is canceled or its deadline passes; the error wraps the
+ * context's error, so errors.Is(err, context.DeadlineExceeded) can be used
+ * to distinguish a timeout.
+ */
+func (p *Program) ExecContext(cxt gocontext.Context, context interface{}) (interface{}, error) {
+	return p.ExecWithOptions(cxt, context, ExecOptions{})
+}
+
+/**
+ * Execute under the provided Go context, subject to the limits described by
+ * the provided options
+ */
+func (p *Program) ExecWithOptions(cxt gocontext.Context, context interface{}, opts ExecOptions) (interface{}, error) {
+	lib, env := p.lib, p.env
+	if opts.Stdlib != nil {
+		var err error
+		lib, err = opts.Stdlib.library()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Libraries != nil {
+		e, err := newFunctionEnv(opts.Libraries)
+		if err != nil {
+			return nil, err
+		}
+		env = e.frame()
+	}
+	for _, e := range p.uses {
+		if _, ok := env[e]; !ok {
+			return nil, errorf(KindUndefined, p.span(), "No such library function '%v': a decoded program must be provided its libraries by ExecOptions.Libraries", e)
+		}
+	}
+	frames := newContext(functionFrame(lib), context)
+	if env != nil {
+		frames.push(functionFrame(env))
+	}
+	v, err := p.eval(newRuntime(cxt, opts), frames)
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = newError(KindRuntime, p.span(), err, "Execution failed")
+		}
+	}
+	return v, err
+}
+
+/**
+ * Print the program's expression tree. By default the tree is printed as
+ * it was parsed; use PrintOptionOptimized to print the optimized tree that
+ * is actually executed.
  */
 func (p *Program) Print(w io.Writer, opts PrintOptions) error {
-	return p.root.print(w, opts, printState{})
+	if p.tree == nil {
+		return fmt.Errorf("Program has no expression tree")
+	} else if (opts & PrintOptionOptimized) == PrintOptionOptimized {
+		return p.root.print(w, opts, printState{})
+	} else {
+		return p.tree.print(w, opts, printState{})
+	}
 }
 
 // Display as source
 func (p *Program) String() string {
 	b := &bytes.Buffer{}
-	p.root.print(b, 0, printState{})
+	if p.tree != nil {
+		p.tree.print(b, 0, printState{})
+	}
 	return string(b.Bytes())
 }
 
@@ -322,6 +595,9 @@ type logicalOrNode struct {
  * Execute
  */
 func (n *logicalOrNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 
 	lvi, err := n.left.exec(runtime, context)
 	if err != nil {
@@ -388,6 +664,9 @@ type logicalAndNode struct {
  * Execute
  */
 func (n *logicalAndNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 
 	lvi, err := n.left.exec(runtime, context)
 	if err != nil {
@@ -455,6 +734,9 @@ type arithmeticNode struct {
  * Execute
  */
 func (n *arithmeticNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 	lvi, err := n.left.exec(runtime, context)
 	if err != nil {
 		return nil, err
@@ -476,7 +758,7 @@ func (n *arithmeticNode) execConcat(runtime *Runtime, context *context, lv strin
 	if err != nil {
 		return nil, err
 	}
-	return lv + rv, nil
+	return runtime.concat(n.span, lv, rv)
 }
 
 func (n *arithmeticNode) execArith(runtime *Runtime, context *context, lvi interface{}) (interface{}, error) {
@@ -494,7 +776,17 @@ func (n *arithmeticNode) execArith(runtime *Runtime, context *context, lvi inter
 		return nil, err
 	}
 
-	switch n.op.which {
+	if n.op.which == tokenMod {
+		return modulo(runtime, n.span, lvi, rvi)
+	}
+	return arithmetic(runtime, n.span, n.op.which, lv, rv)
+}
+
+/**
+ * Apply an arithmetic operator to evaluated operands
+ */
+func arithmetic(runtime *Runtime, s span, op tokenType, lv, rv float64) (interface{}, error) {
+	switch op {
 	case tokenAdd:
 		return lv + rv, nil
 	case tokenSub:
@@ -502,11 +794,75 @@ func (n *arithmeticNode) execArith(runtime *Runtime, context *context, lvi inter
 	case tokenMul:
 		return lv * rv, nil
 	case tokenDiv:
+		if rv == 0 {
+			switch runtime.limits.DivideByZero {
+			case DivideByZeroError:
+				return nil, runtimeErrorf(s, "Divide by zero")
+			case DivideByZeroNil:
+				return nil, nil
+			}
+		}
 		return lv / rv, nil
-	case tokenMod: // truncates to int
-		return int64(lv) % int64(rv), nil
+	default: // modulo is applied to the operands themselves, by modulo
+		return nil, runtimeErrorf(s, "Invalid operator: %v", op)
+	}
+}
+
+/**
+ * Apply the modulo operator to evaluated numeric operands. The operands are
+ * truncated to integers, but unlike the other arithmetic operators they are
+ * not converted to float64 first, so the result is exact for any integer of
+ * up to 64 bits, like a hash. The result is an int64, unless it is too large
+ * for one, in which case it is a uint64.
+ */
+func modulo(runtime *Runtime, s span, lvi, rvi interface{}) (interface{}, error) {
+	lneg, lm, ok := integerOperand(lvi)
+	if !ok {
+		return nil, runtimeErrorf(s, "Operand is out of the range of an integer: %v", lvi)
+	}
+	_, rm, ok := integerOperand(rvi)
+	if !ok {
+		return nil, runtimeErrorf(s, "Operand is out of the range of an integer: %v", rvi)
+	}
+	if rm == 0 {
+		if runtime.limits.DivideByZero == DivideByZeroNil {
+			return nil, nil
+		}
+		return nil, runtimeErrorf(s, "Integer divide by zero")
+	}
+	// the result has the sign of the dividend, as it does in Go
+	m := lm % rm
+	switch {
+	case lneg:
+		return -int64(m), nil
+	case m > math.MaxInt64:
+		return m, nil
+	default:
+		return int64(m), nil
+	}
+}
+
+/**
+ * Obtain the sign and magnitude of a numeric value truncated to an integer
+ */
+func integerOperand(v interface{}) (neg bool, mag uint64, ok bool) {
+	switch x := reflect.ValueOf(v); x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := x.Int()
+		if i < 0 {
+			return true, uint64(-(i + 1)) + 1, true // -MinInt64 is not an int64
+		}
+		return false, uint64(i), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return false, x.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := math.Trunc(x.Float())
+		if math.IsNaN(f) || math.Abs(f) >= 1<<64 {
+			return false, 0, false
+		}
+		return f < 0, uint64(math.Abs(f)), true
 	default:
-		return nil, fmt.Errorf("Invalid operator: %v", n.op)
+		return false, 0, false
 	}
 }
 
@@ -582,6 +938,9 @@ func equal(a, b interface{}) bool {
 	if anil == true && bnil == true {
 		return true
 	}
+	if c, ok := compareOrdered(a, b); ok {
+		return c == 0
+	}
 	switch va.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return equalNumeric(va, vb)
@@ -590,6 +949,9 @@ func equal(a, b interface{}) bool {
 	case reflect.Float32, reflect.Float64:
 		return equalNumeric(va, vb)
 	default:
+		if va.IsValid() && vb.IsValid() && va.Type() == vb.Type() && !va.Type().Comparable() {
+			return reflect.DeepEqual(a, b) // == would panic, like for slices
+		}
 		return a == b
 	}
 }
@@ -619,10 +981,90 @@ func equalNumeric(va, vb reflect.Value) bool {
 	return na == nb
 }
 
+/**
+ * Compare values of types which are ordered but are not numbers, like
+ * times, IP addresses, versions, and UUIDs. The result is negative, zero,
+ * or positive as a is less than, equal to, or greater than b; if the
+ * values cannot be compared this way ok is false. An IP address, version,
+ * or UUID may be compared with a string, which is parsed as one.
+ */
+func compareOrdered(a, b interface{}) (c int, ok bool) {
+	switch va := orderedValue(a).(type) {
+	case time.Time:
+		if vb, ok := orderedValue(b).(time.Time); ok {
+			switch {
+			case va.Before(vb):
+				return -1, true
+			case va.After(vb):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case netip.Addr:
+		if vb, ok := addrOperand(b); ok {
+			return va.Unmap().Compare(vb.Unmap()), true
+		}
+	case Version:
+		if vb, ok := versionOperand(b); ok {
+			return va.Compare(vb), true
+		}
+	case UUID:
+		if vb, ok := uuidOperand(b); ok {
+			return bytes.Compare(va[:], vb[:]), true
+		}
+	case string:
+		switch orderedValue(b).(type) {
+		case netip.Addr, Version, UUID:
+			if c, ok := compareOrdered(b, a); ok {
+				return -c, true
+			}
+		}
+	}
+	return 0, false
+}
+
+/**
+ * Normalize a value of an ordered type, since such values are often
+ * referred to by pointer or have more than one representation, like
+ * addresses as a net.IP. Only a UUID is a UUID; other [16]byte types may
+ * be anything, so they are not converted.
+ */
+func orderedValue(v interface{}) interface{} {
+	switch c := v.(type) {
+	case string, UUID:
+		return v
+	case *time.Time:
+		if c != nil {
+			return *c
+		}
+	case *netip.Addr:
+		if c != nil {
+			return *c
+		}
+	case *Version:
+		if c != nil {
+			return *c
+		}
+	case *UUID:
+		if c != nil {
+			return *c
+		}
+	case net.IP:
+		if a, ok := netip.AddrFromSlice(c); ok {
+			return a
+		}
+	}
+	return v
+}
+
 /**
  * Execute
  */
 func (n *relationalNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 
 	lvi, err := n.left.exec(runtime, context)
 	if err != nil {
@@ -633,23 +1075,45 @@ func (n *relationalNode) exec(runtime *Runtime, context *context) (interface{},
 		return nil, err
 	}
 
-	switch n.op.which {
+	return relational(n.op.which, n.left.src(), n.right.src(), lvi, rvi)
+}
+
+/**
+ * Apply a relational operator to evaluated operands
+ */
+func relational(op tokenType, ls, rs span, lvi, rvi interface{}) (interface{}, error) {
+	switch op {
 	case tokenEqual:
 		return equal(lvi, rvi), nil
 	case tokenNotEqual:
 		return !equal(lvi, rvi), nil
+	case tokenIn:
+		return member(ls, rs, lvi, rvi)
+	}
+
+	if c, ok := compareOrdered(lvi, rvi); ok {
+		switch op {
+		case tokenLess:
+			return c < 0, nil
+		case tokenGreater:
+			return c > 0, nil
+		case tokenLessEqual:
+			return c <= 0, nil
+		case tokenGreaterEqual:
+			return c >= 0, nil
+		}
 	}
 
-	lv, err := asNumber(n.left.src(), lvi)
+	lv, err := asNumber(ls, lvi)
 	if err != nil {
 		return nil, err
 	}
-	rv, err := asNumber(n.right.src(), rvi)
+	rv, err := asNumber(rs, rvi)
 	if err != nil {
 		return nil, err
 	}
 
-	switch n.op.which {
+	switch op {
 	case tokenLess:
 		return lv < rv, nil
 	case tokenGreater:
@@ -659,11 +1123,57 @@ func (n *relationalNode) exec(runtime *Runtime, context *context) (interface{},
 	case tokenGreaterEqual:
 		return lv >= rv, nil
 	default:
-		return nil, fmt.Errorf("Invalid operator: %v", n.op)
+		return nil, runtimeErrorf(encompass(ls, rs), "Invalid operator: %v", op)
 	}
 
 }
 
+/**
+ * Determine if the left operand is a member of the right: an element of a
+ * list or array, a key of a map, a substring of a string, or an address in
+ * a network
+ */
+func member(ls, rs span, lvi, rvi interface{}) (interface{}, error) {
+	if p, ok := rvi.(netip.Prefix); ok {
+		a, ok := addrOperand(lvi)
+		if !ok {
+			return nil, runtimeErrorf(ls, "Invalid left operand to in: %v (expected an IP address)", displayType(reflect.ValueOf(lvi)))
+		}
+		return p.Contains(a) || p.Contains(a.Unmap()), nil
+	}
+
+	v, _ := derefValue(reflect.ValueOf(rvi))
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if equal(lvi, v.Index(i).Interface()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		// a key of an uncomparable type can't be looked up, since MapIndex would
+		// panic hashing it, so the keys are compared one by one instead
+		if k := reflect.ValueOf(lvi); k.IsValid() && k.Type().Comparable() && k.Type().AssignableTo(v.Type().Key()) {
+			return v.MapIndex(k).IsValid(), nil
+		}
+		for _, k := range v.MapKeys() {
+			if equal(lvi, k.Interface()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.String:
+		s := reflect.ValueOf(lvi)
+		if s.Kind() != reflect.String {
+			return nil, runtimeErrorf(ls, "Invalid left operand to in: %v (expected string)", displayType(s))
+		}
+		return strings.Contains(v.String(), s.String()), nil
+	default:
+		return nil, runtimeErrorf(rs, "Invalid right operand to in: %v", displayType(reflect.ValueOf(rvi)))
+	}
+}
+
 /**
  * Print
  */
@@ -687,6 +1197,8 @@ func (n *relationalNode) print(w io.Writer, opts PrintOptions, state printState)
 		op = "<="
 	case tokenGreaterEqual:
 		op = ">="
+	case tokenIn:
+		op = "in"
 	default:
 		return fmt.Errorf("Invalid operator: %v", n.op)
 	}
@@ -714,10 +1226,30 @@ type derefNode struct {
 	left, right executable
 }
 
+/**
+ * Mark the identifier which names a member in the right operand of a
+ * dereference, so that it is resolved against the dereferenced value. The
+ * identifier of a nested dereference or subscript is the left operand of
+ * the expression; the target of an invocation is not a member.
+ */
+func markMember(e executable) {
+	switch n := e.(type) {
+	case *identNode:
+		n.member = true
+	case *derefNode:
+		markMember(n.left)
+	case *indexNode:
+		markMember(n.left)
+	}
+}
+
 /**
  * Execute
  */
 func (n *derefNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 
 	v, err := n.left.exec(runtime, context)
 	if err != nil {
@@ -730,11 +1262,11 @@ func (n *derefNode) exec(runtime *Runtime, context *context) (interface{}, error
 	var z interface{}
 	switch v := n.right.(type) {
 	case *identNode:
-		z, err = context.get(runtime, n.span, v.ident)
+		z, err = context.member(runtime, n.span, v.ident, nil)
 	case *derefNode, *indexNode, *invokeNode:
 		z, err = v.exec(runtime, context)
 	default:
-		return nil, fmt.Errorf("Invalid right operand to . (dereference): %v (%T)", v, v)
+		return nil, runtimeErrorf(n.span, "Invalid right operand to . (dereference): %v (%T)", v, v)
 	}
 
 	return z, err
@@ -780,6 +1312,9 @@ type indexNode struct {
  * Execute
  */
 func (n *indexNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 	left, err := n.left.exec(runtime, context)
 	if err != nil {
 		return nil, err
@@ -790,76 +1325,81 @@ func (n *indexNode) exec(runtime *Runtime, context *context) (interface{}, error
 		return nil, err
 	}
 
+	return subscript(n.span, n.right.src(), left, sub)
+}
+
+/**
+ * Subscript an evaluated operand. The span s is that of the entire
+ * subscript expression and rs is that of the subscript itself.
+ */
+func subscript(s, rs span, left, sub interface{}) (interface{}, error) {
 	val := reflect.ValueOf(sub)
 	if val.Kind() == reflect.Invalid {
-		return nil, runtimeErrorf(n.right.src(), "Subscript expression is nil")
+		return nil, runtimeErrorf(rs, "Subscript expression is nil")
 	}
 
-	context.push(left)
-	defer context.pop()
-
 	deref, _ := derefValue(reflect.ValueOf(left))
 	switch deref.Kind() {
 	case reflect.String: // character index
-		return n.execString(runtime, context, deref, val)
+		return subscriptString(s, rs, deref, val)
 	case reflect.Array, reflect.Slice:
-		return n.execArray(runtime, context, deref, val)
+		return subscriptArray(s, rs, deref, val)
 	case reflect.Map:
-		return n.execMap(runtime, context, deref, val)
+		return subscriptMap(s, rs, deref, val)
 	default:
-		return nil, runtimeErrorf(n.span, "Expression result is not indexable: %v", displayType(deref))
+		return nil, runtimeErrorf(s, "Expression result is not indexable: %v", displayType(deref))
 	}
 }
 
 /**
- * Execute
+ * Subscript a string
  */
-func (n *indexNode) execString(runtime *Runtime, context *context, val reflect.Value, index reflect.Value) (interface{}, error) {
+func subscriptString(s, rs span, val reflect.Value, index reflect.Value) (interface{}, error) {
 	val = reflect.ValueOf([]rune(val.Interface().(string))) // convert to []rune
 
-	i, err := asNumberValue(n.right.src(), index)
+	i, err := asNumberValue(rs, index)
 	if err != nil {
 		return nil, err
 	}
 
 	l := val.Len()
 	if int(i) < 0 || int(i) >= l {
-		return nil, runtimeErrorf(n.span, "Index out-of-bounds: %v", i)
+		return nil, runtimeErrorf(s, "Index out-of-bounds: %v", i)
 	}
 
 	return string(val.Index(int(i)).Interface().(rune)), nil
 }
 
 /**
- * Execute
+ * Subscript an array or slice
  */
-func (n *indexNode) execArray(runtime *Runtime, context *context, val reflect.Value, index reflect.Value) (interface{}, error) {
+func subscriptArray(s, rs span, val reflect.Value, index reflect.Value) (interface{}, error) {
 
-	i, err := asNumberValue(n.right.src(), index)
+	i, err := asNumberValue(rs, index)
 	if err != nil {
 		return nil, err
 	}
 
 	l := val.Len()
 	if int(i) < 0 || int(i) >= l {
-		return nil, runtimeErrorf(n.span, "Index out-of-bounds: %v", i)
+		return nil, runtimeErrorf(s, "Index out-of-bounds: %v", i)
 	}
 
 	return val.Index(int(i)).Interface(), nil
 }
 
 /**
- * Execute
+ * Subscript a map
  */
-func (n *indexNode) execMap(runtime *Runtime, context *context, val reflect.Value, key reflect.Value) (interface{}, error) {
+func subscriptMap(s, rs span, val reflect.Value, key reflect.Value) (interface{}, error) {
 	if !key.Type().AssignableTo(val.Type().Key()) {
-		return nil, runtimeErrorf(n.span, "Expression result is not assignable to map key type: %v != %v", key.Type(), val.Type().Key())
+		return nil, runtimeErrorf(s, "Expression result is not assignable to map key type: %v != %v", key.Type(), val.Type().Key())
 	}
 	val = val.MapIndex(key)
 	if val.IsValid() && !val.IsZero() {
 		return val.Interface(), nil
 	} else {
-		return nil, undefinedVariableError
+		return nil, errorf(KindUndefined, s, "No such key: %v", key)
 	}
 }
 
@@ -904,6 +1444,9 @@ type invokeNode struct {
  * Execute
  */
 func (n *invokeNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 	var liv interface{}
 	var err error
 
@@ -980,13 +1523,20 @@ func (n *invokeNode) print(w io.Writer, opts PrintOptions, state printState) err
  */
 type identNode struct {
 	node
-	ident string
+	ident  string
+	member bool // the identifier names a member of a dereferenced value
 }
 
 /**
  * Execute
  */
 func (n *identNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
+	if n.member {
+		return context.member(runtime, n.span, n.ident, nil)
+	}
 	return context.get(runtime, n.span, n.ident)
 }
 
@@ -1001,6 +1551,48 @@ func (n *identNode) print(w io.Writer, opts PrintOptions, state printState) erro
 	return nil
 }
 
+/**
+ * A UUID identifier. It is resolved like any other identifier, except that
+ * if the context does not define it its value is the UUID itself.
+ */
+type uuidNode struct {
+	identNode
+	value UUID
+}
+
+/**
+ * Execute
+ */
+func (n *uuidNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
+	v, err := context.get(runtime, n.span, n.ident)
+	return resolvedUUID(n.value, v, err)
+}
+
+/**
+ * Produce the value of a UUID identifier from the result of resolving it
+ */
+func resolvedUUID(u UUID, v interface{}, err error) (interface{}, error) {
+	var x *Error
+	if errors.As(err, &x) && x.Kind == KindUndefined && x.Cause == nil {
+		return u, nil
+	}
+	return v, err
+}
+
+/**
+ * Print
+ */
+func (n *uuidNode) print(w io.Writer, opts PrintOptions, state printState) error {
+	_, err := w.Write([]byte(state.Indent() + "uuid:" + n.ident))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 /**
  * A literal expression node
  */
@@ -1013,6 +1605,9 @@ type literalNode struct {
  * Execute
  */
 func (n *literalNode) exec(runtime *Runtime, context *context) (interface{}, error) {
+	if err := runtime.step(n.span); err != nil {
+		return nil, err
+	}
 	return n.value, nil
 }
 
@@ -1088,15 +1683,19 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 	var f reflect.Value
 	if liv != nil {
 		lrv := reflect.ValueOf(liv)
-		f = lrv.MethodByName(name)
+		if e := typeInfoOf(lrv.Type()).member(name); e.method >= 0 {
+			f = lrv.Method(e.method)
+		}
 		if !f.IsValid() {
-			return nil, runtimeErrorf(s, "No such method '%v' for type %v or method is not exported", name, lrv.Type())
+			return nil, noSuchMethodError(s, name, lrv)
 		}
 	} else {
 		var err error
 		liv, err = context.value(runtime, s, name)
-		if err == undefinedVariableError {
-			return nil, undefinedVariableError
+		if e, ok := err.(*Error); ok && e.Kind == KindUndefined {
+			return nil, errorf(KindUndefined, s, "No such function '%v'", name)
+		} else if err != nil {
+			return nil, err
 		} else if liv == nil {
 			return nil, runtimeErrorf(s, "No such function '%v'", name)
 		}
@@ -1106,21 +1705,40 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 		}
 	}
 
-	ft := f.Type()
+	return callFunction(runtime, context, s, f, name, ins)
+}
+
+/**
+ * No such method error
+ */
+func noSuchMethodError(s span, name string, v reflect.Value) error {
+	return runtimeErrorf(s, "No such method '%v' for type %v or method is not exported", name, v.Type())
+}
+
+/**
+ * Call a function which has already been resolved
+ */
+func callFunction(runtime *Runtime, context *context, s span, f reflect.Value, name string, ins []executable) (interface{}, error) {
+	if err := runtime.enter(s); err != nil {
+		return nil, err
+	}
+	defer runtime.leave()
+
+	ft := funcInfoOf(f.Type())
 	lp := len(ins)
 
-	cout := ft.NumOut()
+	cout := len(ft.out)
 	if cout > 2 {
 		return nil, runtimeErrorf(s, "Function %v returns %v values (expected: 0, 1 or 2)", name, cout)
 	}
 
 	in, extra := 0, 1
-	args := make([]reflect.Value, 0)
-	cin := ft.NumIn()
+	cin := len(ft.in)
+	args := make([]reflect.Value, 0, cin)
 
-	if ft.IsVariadic() {
+	if ft.variadic {
 		off := 0
-		if ft.In(in) == typeOfState {
+		if ft.state {
 			off++
 		}
 		if lp < cin-off-1 {
@@ -1137,7 +1755,7 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 		if cin-extra != lp /* allow for runtime parameter */ {
 			return nil, runtimeErrorf(s, "Function takes %v arguments but is given %v: %s([%v,] %s)", cin, lp, name, typeOfState, formatArgs(ins))
 		}
-		if ft.In(in) != typeOfState {
+		if !ft.state {
 			return nil, runtimeErrorf(s, "Function takes %v arguments but is given %v: %s([%v,] %s)", cin, lp, name, typeOfState, formatArgs(ins))
 		}
 		args = append(args, reflect.ValueOf(&State{runtime, context}))
@@ -1149,7 +1767,7 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 		if err != nil {
 			return nil, err
 		}
-		t := ft.In(in)
+		t := ft.in[in]
 		var a reflect.Value
 		if v == nil { // we need a typed zero value if the value is nil
 			a = reflect.Zero(t)
@@ -1167,7 +1785,7 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 	}
 
 	var r []reflect.Value
-	if ft.IsVariadic() {
+	if ft.variadic {
 		r = f.CallSlice(args)
 	} else {
 		r = f.Call(args)
@@ -1179,22 +1797,23 @@ func invokeFunction(runtime *Runtime, context *context, s span, liv interface{},
 	} else if l == 0 {
 		return nil, nil
 	} else if l == 1 {
-		if ft.Out(0) == typeOfError {
+		if ft.out[0] == typeOfError {
 			if !r[0].IsNil() {
-				return nil, r[0].Interface().(error)
+				return nil, functionError(s, name, r[0].Interface().(error))
 			} else {
 				return nil, nil
 			}
 		} else {
-			return r[0].Interface(), nil
+			r0 := r[0].Interface()
+			return r0, runtime.checkSize(s, r0)
 		}
 	} else if l == 2 {
 		r0 := r[0].Interface()
 		r1 := r[1].Interface()
 		if r1 == nil {
-			return r0, nil
+			return r0, runtime.checkSize(s, r0)
 		} else if e, ok := r1.(error); ok {
-			return r0, e
+			return r0, functionError(s, name, e)
 		} else {
 			return nil, runtimeErrorf(s, "Function %v must return either (void), (interface{}) or (interface{}, error)", name)
 		}
@@ -1211,12 +1830,13 @@ type derefOptions int
 const (
 	derefOptionNone           = derefOptions(0)
 	derefOptionDerefFunctions = derefOptions(1 << 0)
+	derefOptionMember         = derefOptions(1 << 1) // resolving the member of a dereferenced value
 )
 
 /**
  * Dereference
  */
-func derefProp(runtime *Runtime, context *context, s span, val interface{}, ident string, opts derefOptions) (interface{}, error) {
+func derefProp(runtime *Runtime, context *context, s span, val interface{}, ident string, opts derefOptions, m *memberCache) (interface{}, error) {
 
 	switch v := val.(type) {
 	case Context:
@@ -1232,13 +1852,15 @@ func derefProp(runtime *Runtime, context *context, s span, val interface{}, iden
 		} else {
 			return nil, undefinedVariableError
 		}
+	case functionFrame:
+		return derefProp(runtime, context, s, map[string]interface{}(v), ident, opts, m)
 	}
 
 	switch v := reflect.ValueOf(val); v.Kind() {
 	case reflect.Map:
 		return derefMap(s, v, ident)
 	case reflect.Ptr, reflect.Struct:
-		return derefMember(runtime, context, s, val, ident, opts)
+		return derefMember(runtime, context, s, val, ident, opts, m)
 	default:
 		return nil, runtimeErrorf(s, "Cannot dereference variable: %v", displayType(v))
 	}
@@ -1262,9 +1884,10 @@ func derefMap(s span, val reflect.Value, property string) (interface{}, error) {
 }
 
 /**
- * Execute
+ * Execute. If a member cache is provided it is used to resolve the method
+ * or field, otherwise the shared type cache is used.
  */
-func derefMember(runtime *Runtime, context *context, s span, val interface{}, property string, opts derefOptions) (interface{}, error) {
+func derefMember(runtime *Runtime, context *context, s span, val interface{}, property string, opts derefOptions, m *memberCache) (interface{}, error) {
 	raw := reflect.ValueOf(val)
 	base := raw
 
@@ -1275,17 +1898,27 @@ func derefMember(runtime *Runtime, context *context, s span, val interface{}, pr
 		return nil, runtimeErrorf(s, "Cannot dereference variable: %v", displayType(base))
 	}
 
-	v := raw.MethodByName(property)
+	var member *memberEntry
+	if m != nil {
+		member = m.resolve(raw.Type(), property)
+	} else {
+		member = typeInfoOf(raw.Type()).member(property)
+	}
+
+	var v reflect.Value
+	if member.method >= 0 {
+		v = raw.Method(member.method)
+	}
 	if v.IsValid() {
 		if (opts & derefOptionDerefFunctions) == derefOptionDerefFunctions {
-			f := v.Type()
-			if n := f.NumOut(); n < 1 {
+			f := funcInfoOf(v.Type())
+			if n := len(f.out); n < 1 {
 				return nil, runtimeErrorf(s, "Method %v of %v returns no values, which cannot be used as a dereference", v, displayType(base))
 			}
-			if f.Out(0) == typeOfError {
+			if f.out[0] == typeOfError {
 				return nil, runtimeErrorf(s, "Method %v of %v returns only an error, which cannot be used as a dereference", v, displayType(base))
 			}
-			return invokeFunction(runtime, context, s, val, property, nil)
+			return callFunction(runtime, context, s, v, property, nil)
 		} else {
 			if !v.CanInterface() {
 				return nil, runtimeErrorf(s, "Cannot access %v of %v", property, displayType(raw))
@@ -1294,7 +1927,9 @@ func derefMember(runtime *Runtime, context *context, s span, val interface{}, pr
 		}
 	}
 
-	v = base.FieldByName(property)
+	if member.field != nil {
+		v = base.FieldByIndex(member.field)
+	}
 	if v.IsValid() {
 		if !v.CanInterface() {
 			return nil, runtimeErrorf(s, "Cannot access %v of %v", property, displayType(raw))
@@ -1330,28 +1965,19 @@ func displayType(v reflect.Value) string {
 }
 
 /**
- * A runtime error
+ * Produce the error for a function which failed
  */
-type runtimeError struct {
-	message string
-	span    span
-	cause   error
+func functionError(s span, name string, err error) *Error {
+	var l *LimitError
+	if errors.As(err, &l) { // a function may refuse to produce a result which would exceed a limit
+		return newLimitError(s, l.Limit, l.Max)
+	}
+	return newError(KindRuntime, s, err, fmt.Sprintf("Function %v failed", name))
 }
 
 /**
  * Format a runtime error
  */
-func runtimeErrorf(s span, f string, a ...interface{}) *runtimeError {
-	return &runtimeError{fmt.Sprintf(f, a...), s, nil}
-}
-
-/**
- * Error
- */
-func (e runtimeError) Error() string {
-	if e.cause != nil {
-		return fmt.Sprintf("%s: %v\n%v", e.message, e.cause, excerptCallout.FormatExcerpt(e.span))
-	} else {
-		return fmt.Sprintf("%s\n%v", e.message, excerptCallout.FormatExcerpt(e.span))
-	}
+func runtimeErrorf(s span, f string, a ...interface{}) *Error {
+	return errorf(KindRuntime, s, f, a...)
 }
@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/**
+ * A source of the current time. Programs obtain the time from the clock
+ * provided to their runtime so that rules which depend on it can be
+ * tested deterministically.
+ */
+type Clock interface {
+	Now() time.Time
+}
+
+/**
+ * The system clock
+ */
+type systemClock struct{}
+
+/**
+ * Obtain the current time
+ */
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+/**
+ * A clock which always reports the same time
+ */
+type fixedClock time.Time
+
+/**
+ * Create a clock which always reports the provided time
+ */
+func FixedClock(t time.Time) Clock {
+	return fixedClock(t)
+}
+
+/**
+ * Obtain the current time
+ */
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+/**
+ * A logger. This is satisfied by *log.Logger.
+ */
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+/**
+ * A random source which is safe for concurrent use; this is used when an
+ * execution does not provide its own source
+ */
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source64
+}
+
+/**
+ * Produce a random value
+ */
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+/**
+ * Produce a random value
+ */
+func (s *lockedSource) Uint64() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Uint64()
+}
+
+/**
+ * Seed the source
+ */
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
+
+var defaultRandom = rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano()).(rand.Source64)})
@@ -45,6 +45,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -52,12 +53,76 @@ import (
 )
 
 /**
- * A text span
+ * A text span. The line and column (both base 1, the column in characters)
+ * describe the position of its offset; a span with no position, like the
+ * zero span, has a line of zero.
  */
 type span struct {
 	text   string
 	offset int
 	length int
+	line   int
+	column int
+}
+
+/**
+ * Create a span, computing its position. When many spans of the same text
+ * are created, a positioner is more efficient.
+ */
+func newSpan(text string, offset, length int) span {
+	return newPositioner(text).span(offset, length)
+}
+
+/**
+ * Computes the positions of spans of a text. Positions are computed most
+ * efficiently when spans are created in order, as they are when scanning.
+ */
+type positioner struct {
+	text   string
+	lines  []int // the offset at which each line begins
+	offset int   // the offset of the last position computed, from which the next one can be resumed
+	line   int
+	column int
+}
+
+/**
+ * Create a positioner
+ */
+func newPositioner(text string) *positioner {
+	lines := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &positioner{text, lines, 0, 1, 1}
+}
+
+/**
+ * Create a span of the text
+ */
+func (p *positioner) span(offset, length int) span {
+	l, c := p.position(offset)
+	return span{p.text, offset, length, l, c}
+}
+
+/**
+ * Obtain the line and column of an offset in the text
+ */
+func (p *positioner) position(offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(p.text) {
+		offset = len(p.text)
+	}
+	l := sort.Search(len(p.lines), func(i int) bool { return p.lines[i] > offset }) // base 1, since the first line begins at zero
+	if l == p.line && offset >= p.offset {
+		p.column += utf8.RuneCountInString(p.text[p.offset:offset])
+	} else {
+		p.column = utf8.RuneCountInString(p.text[p.lines[l-1]:offset]) + 1
+	}
+	p.offset, p.line = offset, l
+	return p.line, p.column
 }
 
 /**
@@ -80,21 +145,21 @@ func (s span) String() string {
  */
 func encompass(a ...span) span {
 	var t string
-	min, max := 0, 0
+	min, max, line, column := 0, 0, 0, 0
 	for i, e := range a {
 		if i == 0 {
-			min, max = e.offset, e.offset+e.length
+			min, max, line, column = e.offset, e.offset+e.length, e.line, e.column
 			t = e.text
 		} else {
 			if e.offset < min {
-				min = e.offset
+				min, line, column = e.offset, e.line, e.column
 			}
 			if e.offset+e.length > max {
 				max = e.offset + e.length
 			}
 		}
 	}
-	return span{t, min, max - min}
+	return span{t, min, max - min, line, column}
 }
 
 /**
@@ -123,10 +188,12 @@ const (
 	tokenString
 	tokenNumber
 	tokenIdentifier
+	tokenUUID
 
 	tokenTrue
 	tokenFalse
 	tokenNil
+	tokenIn
 
 	tokenLParen   = '('
 	tokenRParen   = ')'
@@ -191,12 +258,16 @@ func (t tokenType) String() string {
 		return "Number"
 	case tokenIdentifier:
 		return "Ident"
+	case tokenUUID:
+		return "UUID"
 	case tokenTrue:
 		return "true"
 	case tokenFalse:
 		return "false"
 	case tokenNil:
 		return "nil"
+	case tokenIn:
+		return "in"
 	default:
 		return strconv.QuoteRune(rune(t))
 	}
@@ -235,26 +306,6 @@ func (t token) String() string {
  */
 type scannerAction func(*scanner) scannerAction
 
-/**
- * A scanner error
- */
-type scannerError struct {
-	message string
-	span    span
-	cause   error
-}
-
-/**
- * Error
- */
-func (s *scannerError) Error() string {
-	if s.cause != nil {
-		return fmt.Sprintf("%s: %v\n%v", s.message, s.cause, excerptCallout.FormatExcerpt(s.span))
-	} else {
-		return fmt.Sprintf("%s\n%v", s.message, excerptCallout.FormatExcerpt(s.span))
-	}
-}
-
 /**
  * A scanner
  */
@@ -266,6 +317,7 @@ type scanner struct {
 	depth  int // expression depth
 	tokens chan token
 	state  scannerAction
+	pos    *positioner
 }
 
 /**
@@ -273,7 +325,7 @@ type scanner struct {
  */
 func newScanner(text string) *scanner {
 	t := make(chan token, 5 /* several tokens may be produced in one iteration */)
-	return &scanner{text, 0, 0, 0, 0, t, expressionAction}
+	return &scanner{text, 0, 0, 0, 0, t, expressionAction, newPositioner(text)}
 }
 
 /**
@@ -285,6 +337,9 @@ func (s *scanner) scan() token {
 		case t := <-s.tokens:
 			return t
 		default:
+			if s.state == nil { // the input is exhausted or scanning failed
+				return token{s.spanAt(len(s.text), 0), tokenEOF, nil}
+			}
 			s.state = s.state(s)
 		}
 	}
@@ -293,8 +348,15 @@ func (s *scanner) scan() token {
 /**
  * Create an error
  */
-func (s *scanner) errorf(where span, cause error, format string, args ...interface{}) *scannerError {
-	return &scannerError{fmt.Sprintf(format, args...), where, cause}
+func (s *scanner) errorf(where span, cause error, format string, args ...interface{}) *Error {
+	return newError(KindSyntax, where, cause, fmt.Sprintf(format, args...))
+}
+
+/**
+ * Create a span of the input
+ */
+func (s *scanner) spanAt(offset, length int) span {
+	return s.pos.span(offset, length)
 }
 
 /**
@@ -308,7 +370,7 @@ func (s *scanner) emit(t token) {
 /**
  * Emit an error and return a nil action
  */
-func (s *scanner) error(err *scannerError) scannerAction {
+func (s *scanner) error(err *Error) scannerAction {
 	s.tokens <- token{err.span, tokenError, err}
 	return nil
 }
@@ -495,7 +557,7 @@ func expressionAction(s *scanner) scannerAction {
 		switch {
 
 		case r == eof:
-			s.emit(token{span{s.text, len(s.text), 0}, tokenEOF, nil})
+			s.emit(token{s.spanAt(len(s.text), 0), tokenEOF, nil})
 			return nil
 
 		case unicode.IsSpace(r):
@@ -514,71 +576,74 @@ func expressionAction(s *scanner) scannerAction {
 			return identifierAction
 
 		case r == '(' || r == ')' || r == '[' || r == ']' || r == '.' || r == ',' || r == ';':
-			s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+			s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			return expressionAction
 
 		case r == '&':
 			if n := s.next(); n == '=' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenSuffixEqual | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenSuffixEqual | r), string(r)})
 			} else if n == '&' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenPrefixAmp | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenPrefixAmp | r), string(r)})
 			} else {
 				s.backup()
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			}
 			return expressionAction
 
 		case r == '|':
 			if n := s.next(); n == '=' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenSuffixEqual | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenSuffixEqual | r), string(r)})
 			} else if n == '|' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenPrefixPipe | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenPrefixPipe | r), string(r)})
 			} else {
 				s.backup()
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			}
 			return expressionAction
 
 		case r == '+':
 			if n := s.next(); n == '=' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenSuffixEqual | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenSuffixEqual | r), string(r)})
 			} else if n == '+' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenPrefixAdd | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenPrefixAdd | r), string(r)})
 			} else if n >= '0' && n <= '9' {
 				s.backup() // unget the first digit; leave the sign
 				return numberAction
 			} else {
 				s.backup()
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			}
 			return expressionAction
 
 		case r == '-':
 			if n := s.next(); n == '=' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenSuffixEqual | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenSuffixEqual | r), string(r)})
 			} else if n == '-' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenPrefixSub | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenPrefixSub | r), string(r)})
 			} else if n >= '0' && n <= '9' {
 				s.backup()
 				s.backup() // unget the sign and the first digit
 				return numberAction
 			} else {
 				s.backup()
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			}
 			return expressionAction
 
 		case r == '=' || r == '!' || r == '<' || r == '>' || r == ':' || r == '*' || r == '/' || r == '%':
 			if n := s.next(); n == '=' {
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(tokenSuffixEqual | r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(tokenSuffixEqual | r), string(r)})
 			} else {
 				s.backup()
-				s.emit(token{span{s.text, s.start, s.index - s.start}, tokenType(r), string(r)})
+				s.emit(token{s.spanAt(s.start, s.index-s.start), tokenType(r), string(r)})
 			}
 			return expressionAction
 
 		default:
-			return s.error(s.errorf(span{s.text, s.index, 1}, nil, "Syntax error"))
+			// the character is skipped so that scanning can resume after it
+			s.error(s.errorf(s.spanAt(s.index-s.width, s.width), nil, "Syntax error"))
+			s.ignore()
+			return expressionAction
 
 		}
 	}
@@ -591,14 +656,14 @@ func expressionAction(s *scanner) scannerAction {
  */
 func stringAction(s *scanner) scannerAction {
 	if v, err := s.scanString('"', '\\'); err != nil {
-		var serr *scannerError
+		var serr *Error
 		if errors.As(err, &serr) {
 			s.error(serr)
 		} else {
-			s.error(s.errorf(span{s.text, s.index, 1}, err, "Invalid string"))
+			s.error(s.errorf(s.spanAt(s.index, 1), err, "Invalid string"))
 		}
 	} else {
-		s.emit(token{span{s.text, s.start, s.index - s.start}, tokenString, v})
+		s.emit(token{s.spanAt(s.start, s.index-s.start), tokenString, v})
 	}
 	return expressionAction
 }
@@ -608,14 +673,14 @@ func stringAction(s *scanner) scannerAction {
  */
 func numberAction(s *scanner) scannerAction {
 	if v, _, err := s.scanNumber(); err != nil {
-		var serr *scannerError
+		var serr *Error
 		if errors.As(err, &serr) {
 			s.error(serr)
 		} else {
-			s.error(s.errorf(span{s.text, s.index, 1}, err, "Invalid number"))
+			s.error(s.errorf(s.spanAt(s.index, 1), err, "Invalid number"))
 		}
 	} else {
-		s.emit(token{span{s.text, s.start, s.index - s.start}, tokenNumber, v})
+		s.emit(token{s.spanAt(s.start, s.index-s.start), tokenNumber, v})
 	}
 	return expressionAction
 }
@@ -625,17 +690,17 @@ func numberAction(s *scanner) scannerAction {
  */
 func identifierAction(s *scanner) scannerAction {
 
-	v, err := s.scanIdentifierOrUUID()
+	v, uuid, err := s.scanIdentifierOrUUID()
 	if err != nil {
-		var serr *scannerError
+		var serr *Error
 		if errors.As(err, &serr) {
 			s.error(serr)
 		} else {
-			s.error(s.errorf(span{s.text, s.index, 1}, err, "Invalid identifier"))
+			s.error(s.errorf(s.spanAt(s.index, 1), err, "Invalid identifier"))
 		}
 	}
 
-	t := span{s.text, s.start, s.index - s.start}
+	t := s.spanAt(s.start, s.index-s.start)
 	switch v {
 	case "true":
 		s.emit(token{t, tokenTrue, v})
@@ -643,8 +708,14 @@ func identifierAction(s *scanner) scannerAction {
 		s.emit(token{t, tokenFalse, v})
 	case "nil":
 		s.emit(token{t, tokenNil, nil})
+	case "in":
+		s.emit(token{t, tokenIn, v})
 	default:
-		s.emit(token{t, tokenIdentifier, v})
+		if uuid && err == nil {
+			s.emit(token{t, tokenUUID, v})
+		} else {
+			s.emit(token{t, tokenIdentifier, v})
+		}
 	}
 
 	return expressionAction
@@ -665,11 +736,11 @@ func (s *scanner) scanString(quote, escape rune) (string, error) {
 		switch r := s.next(); {
 
 		case r == eof:
-			return "", s.errorf(span{s.text, s.start, s.index - s.start}, nil, "Unexpected end-of-input")
+			return "", s.errorf(s.spanAt(s.start, s.index-s.start), nil, "Unexpected end-of-input")
 
 		case r == escape:
 			if e, err := s.scanEscape(quote, escape); err != nil {
-				return "", s.errorf(span{s.text, s.start, s.index - s.start}, err, "Invalid escape sequence")
+				return "", s.errorf(s.spanAt(s.start, s.index-s.start), err, "Invalid escape sequence")
 			} else {
 				unquoted += string(e)
 			}
@@ -683,7 +754,7 @@ func (s *scanner) scanString(quote, escape rune) (string, error) {
 		}
 	}
 
-	return "", s.errorf(span{s.text, s.start, s.index - s.start}, nil, "Unexpected end-of-input")
+	return "", s.errorf(s.spanAt(s.start, s.index-s.start), nil, "Unexpected end-of-input")
 }
 
 /**
@@ -699,9 +770,9 @@ func (s *scanner) scanIdentifier() (string, error) {
 }
 
 /**
- * Scan an identifier or UUID
+ * Scan an identifier or UUID, which is described by the result
  */
-func (s *scanner) scanIdentifierOrUUID() (string, error) {
+func (s *scanner) scanIdentifierOrUUID() (string, bool, error) {
 	var start int
 
 	u, _ := s.matchAny("U:", "u:")
@@ -719,7 +790,7 @@ func (s *scanner) scanIdentifierOrUUID() (string, error) {
 		}
 
 		if n != 32 {
-			return "", s.errorf(span{s.text, start, s.index - start}, nil, "UUID identifier is incorrectly formatted")
+			return "", true, s.errorf(s.spanAt(start, s.index-start), nil, "UUID identifier is incorrectly formatted")
 		}
 	} else {
 
@@ -731,7 +802,7 @@ func (s *scanner) scanIdentifierOrUUID() (string, error) {
 	}
 
 	s.backup() // unget the last character
-	return s.text[start:s.index], nil
+	return s.text[start:s.index], u, nil
 }
 
 /**
@@ -767,7 +838,7 @@ func (s *scanner) scanDigits(base, n int) (string, error) {
 	}
 	s.backup() // unget the stop character
 	if n > 0 {
-		return "", s.errorf(span{s.text, start, s.index - start}, nil, "Not enough digits")
+		return "", s.errorf(s.spanAt(start, s.index-start), nil, "Not enough digits")
 	} else {
 		return s.text[start:s.index], nil
 	}
@@ -827,7 +898,7 @@ func (s *scanner) scanEscape(quote, esc rune) (rune, error) {
 	case 'U':
 		return s.scanRune(16, 8)
 	default:
-		return 0, s.errorf(span{s.text, start, s.index - start}, nil, "Invalid escape sequence")
+		return 0, s.errorf(s.spanAt(start, s.index-start), nil, "Invalid escape sequence")
 	}
 }
 
@@ -895,11 +966,11 @@ func (s *scanner) scanNumber() (float64, numericType, error) {
 			s.backup()
 
 			if !hasMantissa {
-				return 0, 0, s.errorf(span{s.text, start, s.index - start}, nil, "Illegal hexadecimal number")
+				return 0, 0, s.errorf(s.spanAt(start, s.index-start), nil, "Illegal hexadecimal number")
 			}
 
 			if v, err := strconv.ParseInt(s.text[start+2:s.index], 16, 64); err != nil {
-				return 0, 0, s.errorf(span{s.text, start, s.index - start}, err, "Could not parse number")
+				return 0, 0, s.errorf(s.spanAt(start, s.index-start), err, "Could not parse number")
 			} else {
 				return float64(v), numericInteger, nil
 			}
@@ -925,13 +996,13 @@ func (s *scanner) scanNumber() (float64, numericType, error) {
 
 			// octal int
 			if has8or9 {
-				s.errorf(span{s.text, start, s.index - start}, nil, "Illegal octal number")
+				s.errorf(s.spanAt(start, s.index-start), nil, "Illegal octal number")
 			}
 
 			// parse our octal
 			t := s.text[start:s.index]
 			if v, err := strconv.ParseInt(t, 8, 64); err != nil {
-				return 0, 0, s.errorf(span{s.text, start, s.index - start}, err, "Could not parse number")
+				return 0, 0, s.errorf(s.spanAt(start, s.index-start), err, "Could not parse number")
 			} else {
 				return float64(v), numericInteger, nil
 			}
@@ -962,13 +1033,13 @@ fraction:
 	// parse the base-10 number
 	if isfloat {
 		if v, err := strconv.ParseFloat(s.text[start:s.index], 64); err != nil {
-			return 0, 0, s.errorf(span{s.text, start, s.index - start}, err, "Could not parse number")
+			return 0, 0, s.errorf(s.spanAt(start, s.index-start), err, "Could not parse number")
 		} else {
 			return v, numericFloat, nil
 		}
 	} else {
 		if v, err := strconv.ParseInt(s.text[start:s.index], 10, 64); err != nil {
-			return 0, 0, s.errorf(span{s.text, start, s.index - start}, err, "Could not parse number")
+			return 0, 0, s.errorf(s.spanAt(start, s.index-start), err, "Could not parse number")
 		} else {
 			return float64(v), numericInteger, nil
 		}
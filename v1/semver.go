@@ -0,0 +1,399 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A semantic version, as described by https://semver.org. Versions are
+ * ordered by precedence, so when they are compared the build metadata is
+ * ignored and a pre-release version is less than the corresponding
+ * release version.
+ */
+type Version struct {
+	Major, Minor, Patch uint64
+	Prerelease          string // dot-separated identifiers, like "beta.2", if any
+	Build               string // dot-separated build metadata, if any
+}
+
+/**
+ * Parse a semantic version. A leading 'v' is permitted and the minor and
+ * patch versions may be omitted, in which case they are zero; so "v2.3"
+ * is equivalent to "2.3.0".
+ */
+func ParseVersion(s string) (Version, error) {
+	v, n, err := parseVersion(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if n < 3 && (v.Prerelease != "" || v.Build != "") {
+		return Version{}, fmt.Errorf("Invalid version: %q (a pre-release version must be complete)", s)
+	}
+	return v, nil
+}
+
+/**
+ * Parse a version, which may be partial, producing the number of its
+ * components which are provided
+ */
+func parseVersion(s string) (Version, int, error) {
+	var v Version
+	r := s
+	if strings.HasPrefix(r, "v") || strings.HasPrefix(r, "V") {
+		r = r[1:]
+	}
+	if i := strings.IndexByte(r, '+'); i >= 0 {
+		v.Build, r = r[i+1:], r[:i]
+		if !validVersionIdentifiers(v.Build, false) {
+			return Version{}, 0, fmt.Errorf("Invalid version: %q (invalid build metadata)", s)
+		}
+	}
+	if i := strings.IndexByte(r, '-'); i >= 0 {
+		v.Prerelease, r = r[i+1:], r[:i]
+		if !validVersionIdentifiers(v.Prerelease, true) {
+			return Version{}, 0, fmt.Errorf("Invalid version: %q (invalid pre-release version)", s)
+		}
+	}
+	c := strings.Split(r, ".")
+	if len(c) > 3 {
+		return Version{}, 0, fmt.Errorf("Invalid version: %q", s)
+	}
+	p := [3]*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, e := range c {
+		n, err := parseVersionNumber(e)
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("Invalid version: %q", s)
+		}
+		*p[i] = n
+	}
+	return v, len(c), nil
+}
+
+/**
+ * Parse a numeric component of a version, which may not have leading zeros
+ */
+func parseVersionNumber(s string) (uint64, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("Leading zero")
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("Not a number")
+		}
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+/**
+ * Determine if dot-separated identifiers are valid. Numeric identifiers of
+ * a pre-release version may not have leading zeros.
+ */
+func validVersionIdentifiers(s string, pre bool) bool {
+	for _, e := range strings.Split(s, ".") {
+		if e == "" {
+			return false
+		}
+		numeric := true
+		for _, r := range e {
+			switch {
+			case r >= '0' && r <= '9':
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '-':
+				numeric = false
+			default:
+				return false
+			}
+		}
+		if pre && numeric && len(e) > 1 && e[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Format a version
+ */
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+/**
+ * Compare versions by precedence. The result is negative, zero, or
+ * positive as v is less than, equal to, or greater than o.
+ */
+func (v Version) Compare(o Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrerelease(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
+
+/**
+ * Compare pre-release identifiers. Numeric identifiers are compared
+ * numerically and have lower precedence than alphanumeric identifiers,
+ * which are compared lexically.
+ */
+func comparePrerelease(a, b string) int {
+	na, aerr := strconv.ParseUint(a, 10, 64)
+	nb, berr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aerr == nil && berr == nil:
+		return compareUint(na, nb)
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+/**
+ * Compare integers
+ */
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+/**
+ * A version constraint, like ">=1.2 <2.0 || ^3.1". Comparators separated
+ * by whitespace or commas must all be satisfied; groups of comparators
+ * separated by "||" are alternatives.
+ */
+type versionConstraint [][]versionComparator
+
+/**
+ * A comparison with a version; the operator is one of "=", "!=", "<",
+ * "<=", ">", or ">="
+ */
+type versionComparator struct {
+	op string
+	v  Version
+}
+
+/**
+ * Parse a version constraint. A comparator is a version preceded by an
+ * operator; in addition to the relational operators, these are supported:
+ *
+ *   1.2.3, =1.2.3  exactly 1.2.3
+ *   ~1.2.3         at least 1.2.3 with the same minor version; ~1 is 1.x
+ *   ^1.2.3         at least 1.2.3 with the same major version, or the same
+ *                  minor version if the major version is zero
+ *   1.2.x, 1.2     any patch version of 1.2; likewise 1.x, 1, and *
+ *
+ * A partial version in a relational comparator is completed with zeros, so
+ * "<2.0" is "<2.0.0".
+ */
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, g := range strings.Split(s, "||") {
+		var set []versionComparator
+		f := strings.FieldsFunc(g, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		if len(f) == 0 {
+			return nil, fmt.Errorf("Invalid version constraint: %q (no comparators)", s)
+		}
+		for i := 0; i < len(f); i++ {
+			e := f[i]
+			if strings.Trim(e, "<>=!~^") == "" && i+1 < len(f) { // an operator separated from its version
+				i++
+				e += f[i]
+			}
+			x, err := parseVersionComparator(e)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version constraint: %q (%v)", s, err)
+			}
+			set = append(set, x...)
+		}
+		c = append(c, set)
+	}
+	return c, nil
+}
+
+/**
+ * Parse a single comparator, which produces the relational comparisons
+ * it is equivalent to
+ */
+func parseVersionComparator(s string) ([]versionComparator, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "<>=!~^"))]
+	r := s[len(op):]
+	switch op {
+	case "", "=", "==", "!=", "<", "<=", ">", ">=", "~", "^":
+	default:
+		return nil, fmt.Errorf("invalid operator: %q", op)
+	}
+
+	// wildcards are equivalent to a partial version
+	if r == "*" || r == "x" || r == "X" {
+		if op != "" && op != "=" && op != "==" {
+			return nil, fmt.Errorf("invalid wildcard: %q", s)
+		}
+		return nil, nil
+	}
+	for strings.HasSuffix(r, ".*") || strings.HasSuffix(r, ".x") || strings.HasSuffix(r, ".X") {
+		r = r[:len(r)-2]
+	}
+
+	v, n, err := parseVersion(r)
+	if err != nil {
+		return nil, err
+	}
+	if n < 3 && v.Prerelease != "" {
+		return nil, fmt.Errorf("incomplete pre-release version: %q", r)
+	}
+	v.Build = ""
+
+	// the least version which is greater than every version matching the
+	// partial version
+	next := v
+	switch n {
+	case 1:
+		next = Version{Major: v.Major + 1}
+	case 2:
+		next = Version{Major: v.Major, Minor: v.Minor + 1}
+	}
+
+	switch op {
+	case "", "=", "==":
+		if n == 3 {
+			return []versionComparator{{"=", v}}, nil
+		}
+		return []versionComparator{{">=", v}, {"<", next}}, nil
+	case "!=":
+		if n < 3 {
+			return nil, fmt.Errorf("incomplete version: %q", s)
+		}
+		return []versionComparator{{"!=", v}}, nil
+	case "<", ">=":
+		return []versionComparator{{op, v}}, nil
+	case "<=":
+		if n < 3 {
+			return []versionComparator{{"<", next}}, nil
+		}
+		return []versionComparator{{op, v}}, nil
+	case ">":
+		if n < 3 {
+			return []versionComparator{{">=", next}}, nil
+		}
+		return []versionComparator{{op, v}}, nil
+	case "~":
+		if n == 1 {
+			return []versionComparator{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+		}
+		return []versionComparator{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	default: // "^"
+		switch {
+		case v.Major > 0 || n == 1:
+			return []versionComparator{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+		case v.Minor > 0 || n == 2:
+			return []versionComparator{{">=", v}, {"<", Version{Minor: v.Minor + 1}}}, nil
+		default:
+			return []versionComparator{{">=", v}, {"<", Version{Patch: v.Patch + 1}}}, nil
+		}
+	}
+}
+
+/**
+ * Determine if a version satisfies the constraint
+ */
+func (c versionConstraint) match(v Version) bool {
+	for _, set := range c {
+		if matchVersionComparators(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Determine if a version satisfies every comparator in a set
+ */
+func matchVersionComparators(set []versionComparator, v Version) bool {
+	for _, e := range set {
+		c := v.Compare(e.v)
+		var ok bool
+		switch e.op {
+		case "=":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
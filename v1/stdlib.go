@@ -33,7 +33,7 @@ package epl
 import (
   "os"
   "fmt"
-  "regexp"
+  "math"
   "reflect"
 )
 
@@ -62,6 +62,222 @@ var stdlib = map[string]interface{}{
   "len": builtInLen,
   "match": builtInMatch,
   "printf": builtInPrintf,
+  // strings
+  "contains": builtInContains,
+  "hasPrefix": builtInHasPrefix,
+  "hasSuffix": builtInHasSuffix,
+  "lower": builtInLower,
+  "upper": builtInUpper,
+  "title": builtInTitle,
+  "trim": builtInTrim,
+  "trimLeft": builtInTrimLeft,
+  "trimRight": builtInTrimRight,
+  "trimPrefix": builtInTrimPrefix,
+  "trimSuffix": builtInTrimSuffix,
+  "split": builtInSplit,
+  "fields": builtInFields,
+  "join": builtInJoin,
+  "replace": builtInReplace,
+  "index": builtInIndex,
+  "repeat": builtInRepeat,
+  "equalFold": builtInEqualFold,
+  // math
+  "abs": builtInAbs,
+  "floor": builtInFloor,
+  "ceil": builtInCeil,
+  "round": builtInRound,
+  "min": builtInMin,
+  "max": builtInMax,
+  "clamp": builtInClamp,
+  "pow": builtInPow,
+  "sqrt": builtInSqrt,
+  "log": builtInLog,
+  "exp": builtInExp,
+  "isNaN": builtInIsNaN,
+  "isInf": builtInIsInf,
+  // time
+  "now": builtInNow,
+  "since": builtInSince,
+  "date": builtInDate,
+  "duration": builtInDuration,
+  "parseTime": builtInParseTime,
+  "format": builtInFormat,
+  "year": builtInYear,
+  "month": builtInMonth,
+  "day": builtInDay,
+  "hour": builtInHour,
+  "minute": builtInMinute,
+  "weekday": builtInWeekday,
+  "startOf": builtInStartOf,
+  "inZone": builtInInZone,
+  // conversion and introspection
+  "int": builtInInt,
+  "float": builtInFloat,
+  "string": builtInString,
+  "bool": builtInBool,
+  "typeof": builtInTypeof,
+  "isNil": builtInIsNil,
+  "isString": builtInIsString,
+  "isNumber": builtInIsNumber,
+  // regular expressions
+  "find": builtInFind,
+  "findAll": builtInFindAll,
+  "submatch": builtInSubmatch,
+  "replaceRegex": builtInReplaceRegex,
+  // network
+  "ip": builtInIP,
+  "cidr": builtInCIDR,
+  "isPrivateIP": builtInIsPrivateIP,
+  "isIPv6": builtInIsIPv6,
+  "hostMatch": builtInHostMatch,
+  // versions
+  "semver": builtInSemver,
+  "satisfies": builtInSatisfies,
+  // UUIDs
+  "uuid": builtInUUID,
+  "isUUID": builtInIsUUID,
+  "uuidVersion": builtInUUIDVersion,
+  "uuidVariant": builtInUUIDVariant,
+  // encoding and hashing
+  "base64Encode": builtInBase64Encode,
+  "base64Decode": builtInBase64Decode,
+  "hexEncode": builtInHexEncode,
+  "urlEncode": builtInURLEncode,
+  "urlDecode": builtInURLDecode,
+  "sha256": builtInSHA256,
+  "fnv64": builtInFNV64,
+  // URLs and email addresses
+  "url": builtInURL,
+  "query": builtInQuery,
+  "isURL": builtInIsURL,
+  "email": builtInEmail,
+  "isEmail": builtInIsEmail,
+}
+
+/**
+ * Builtins which access the process environment or perform I/O. These are
+ * excluded from a sandboxed standard library.
+ */
+var stdlibUnsafe = map[string]bool{
+  "env": true,
+  "printf": true,
+}
+
+/**
+ * Standard library options. These determine which builtins are available
+ * to a program; by default all of them are.
+ */
+type StdlibOptions struct {
+  Sandboxed bool      // exclude builtins which access the process environment or perform I/O (env, printf)
+  Allow     []string  // when non-nil, only the named builtins are available
+}
+
+/**
+ * Produce the library described by these options
+ */
+func (o *StdlibOptions) library() (map[string]interface{}, error) {
+  if o == nil || (!o.Sandboxed && o.Allow == nil) {
+    return stdlib, nil
+  }
+  
+  allow := make(map[string]bool)
+  if o.Allow == nil {
+    for k, _ := range stdlib {
+      allow[k] = true
+    }
+  }else{
+    for _, k := range o.Allow {
+      if _, ok := stdlib[k]; !ok {
+        return nil, errorf(KindCompile, span{}, "No such builtin: %v", k)
+      }
+      allow[k] = true
+    }
+  }
+  
+  lib := make(map[string]interface{})
+  for k, _ := range allow {
+    if !o.Sandboxed || !stdlibUnsafe[k] {
+      lib[k] = stdlib[k]
+    }
+  }
+  
+  return lib, nil
+}
+
+/**
+ * Invalid parameter error. The signature describes the parameters of the
+ * builtin and the index of the invalid parameter is base 1.
+ */
+func invalidParameterError(sig string, i int, v interface{}, expect string) error {
+  return fmt.Errorf("Invalid parameter to: %s (parameter %d is %v, expected %s)", sig, i, displayType(reflect.ValueOf(v)), expect)
+}
+
+/**
+ * Invalid parameter value error, for a parameter of the right type with a
+ * value which is not acceptable
+ */
+func invalidParameterValueError(sig string, i int, v interface{}, expect string) error {
+  return fmt.Errorf("Invalid parameter to: %s (parameter %d is %#v, expected %s)", sig, i, v, expect)
+}
+
+/**
+ * Invalid parameter count error
+ */
+func invalidParameterCountError(sig string, n int) error {
+  return fmt.Errorf("Invalid number of parameters to: %s (given %d)", sig, n)
+}
+
+/**
+ * Obtain parameters which must be strings
+ */
+func stringParams(sig string, v ...interface{}) ([]string, error) {
+  s := make([]string, len(v))
+  for i, e := range v {
+    c, ok := e.(string)
+    if !ok {
+      return nil, invalidParameterError(sig, i + 1, e, "string")
+    }
+    s[i] = c
+  }
+  return s, nil
+}
+
+/**
+ * Obtain parameters which must be numbers
+ */
+func numberParams(sig string, v ...interface{}) ([]float64, error) {
+  n := make([]float64, len(v))
+  for i, e := range v {
+    c, err := asNumber(span{}, e)
+    if err != nil {
+      return nil, invalidParameterError(sig, i + 1, e, "number")
+    }
+    n[i] = c
+  }
+  return n, nil
+}
+
+/**
+ * Obtain a parameter which must be an integer. Numbers are floating point
+ * in EPL, so any numeric value which is an integer is accepted.
+ */
+func intParam(sig string, i int, v interface{}) (int, error) {
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      if n := rv.Int(); n >= math.MinInt32 && n <= math.MaxInt32 {
+        return int(n), nil
+      }
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+      if n := rv.Uint(); n <= math.MaxInt32 {
+        return int(n), nil
+      }
+    case reflect.Float32, reflect.Float64:
+      if f := rv.Float(); f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+        return int(f), nil
+      }
+  }
+  return 0, invalidParameterError(sig, i, v, "int")
 }
 
 /**
@@ -89,7 +305,11 @@ func builtInMatch(e, v interface{}) (bool, error) {
   if sv, ok = v.(string); !ok {
     return false, fmt.Errorf("Invalid parameter to: match(string, string)")
   }
-  return regexp.MatchString(se, sv)
+  x, err := regexes.compile(se)
+  if err != nil {
+    return false, err
+  }
+  return x.MatchString(sv), nil
 }
 
 /**
@@ -0,0 +1,169 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/**
+ * int(x); a number truncated toward zero, a string parsed as a number and
+ * truncated, or a bool as 1 or 0
+ */
+func builtInInt(x interface{}) (int64, error) {
+	const sig = "int(any)"
+	f, err := convertNumber(sig, x)
+	if err != nil {
+		return 0, err
+	}
+	f = math.Trunc(f)
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, invalidParameterValueError(sig, 1, x, "number in the range of an integer")
+	}
+	return int64(f), nil
+}
+
+/**
+ * float(x); a number, a string parsed as a number, or a bool as 1 or 0
+ */
+func builtInFloat(x interface{}) (float64, error) {
+	return convertNumber("float(any)", x)
+}
+
+/**
+ * Convert a value to a number
+ */
+func convertNumber(sig string, x interface{}) (float64, error) {
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		if err != nil {
+			return 0, invalidParameterValueError(sig, 1, x, "numeric string")
+		}
+		return f, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	f, err := asNumberValue(span{}, v)
+	if err != nil {
+		return 0, invalidParameterError(sig, 1, x, "number, string, or bool")
+	}
+	return f, nil
+}
+
+/**
+ * string(x); a string as it is, a number formatted without an exponent, a
+ * bool as "true" or "false", nil as "nil", and any other value as it is
+ * formatted by fmt.Sprint
+ */
+func builtInString(x interface{}) string {
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return "nil"
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, ok := x.(fmt.Stringer); !ok { // like time.Duration
+			return strconv.FormatInt(v.Int(), 10)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if _, ok := x.(fmt.Stringer); !ok {
+			return strconv.FormatUint(v.Uint(), 10)
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := x.(fmt.Stringer); !ok {
+			return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		}
+	}
+	return fmt.Sprint(x)
+}
+
+/**
+ * bool(x); a number is true if it is non-zero, as it is when used as an
+ * operand, and a string is parsed, like "true" or "0"
+ */
+func builtInBool(x interface{}) (bool, error) {
+	const sig = "bool(any)"
+	v := reflect.ValueOf(x)
+	if v.Kind() == reflect.String {
+		b, err := strconv.ParseBool(strings.TrimSpace(v.String()))
+		if err != nil {
+			return false, invalidParameterValueError(sig, 1, x, "boolean string")
+		}
+		return b, nil
+	}
+	b, err := asBool(span{}, x)
+	if err != nil {
+		return false, invalidParameterError(sig, 1, x, "bool, number, or string")
+	}
+	return b, nil
+}
+
+/**
+ * typeof(x); the name of the type of a value, like "float64" or "string",
+ * or "<nil>"
+ */
+func builtInTypeof(x interface{}) string {
+	return displayType(reflect.ValueOf(x))
+}
+
+/**
+ * isNil(x); a nil pointer, map, slice, or other reference is nil, as it is
+ * when compared to nil
+ */
+func builtInIsNil(x interface{}) bool {
+	return equal(x, nil)
+}
+
+/**
+ * isString(x)
+ */
+func builtInIsString(x interface{}) bool {
+	return reflect.ValueOf(x).Kind() == reflect.String
+}
+
+/**
+ * isNumber(x); any numeric value, whatever its type
+ */
+func builtInIsNumber(x interface{}) bool {
+	return isNumericKind(reflect.ValueOf(x).Kind())
+}
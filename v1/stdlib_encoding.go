@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"net/url"
+)
+
+/**
+ * Obtain a parameter which must be a string or a byte slice, as bytes
+ */
+func bytesParam(sig string, i int, v interface{}) ([]byte, error) {
+	switch c := v.(type) {
+	case string:
+		return []byte(c), nil
+	case []byte:
+		return c, nil
+	default:
+		return nil, invalidParameterError(sig, i, v, "string or []byte")
+	}
+}
+
+/**
+ * base64Encode(s); standard base64 encoding, with padding
+ */
+func builtInBase64Encode(state *State, s interface{}) (string, error) {
+	const sig = "base64Encode(string)"
+	b, err := bytesParam(sig, 1, s)
+	if err != nil {
+		return "", err
+	}
+	// the result is checked before it is produced, since it may be huge
+	if len(b) > math.MaxInt32/4*3 {
+		return "", invalidParameterError(sig, 1, s, "shorter string or []byte")
+	} else if err := checkStringLength(state, base64.StdEncoding.EncodedLen(len(b))); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+/**
+ * base64Decode(s); standard or URL-safe base64, with or without padding
+ */
+func builtInBase64Decode(s interface{}) (string, error) {
+	const sig = "base64Decode(string)"
+	p, err := stringParams(sig, s)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := e.DecodeString(p[0]); err == nil {
+			return string(b), nil
+		}
+	}
+	return "", invalidParameterValueError(sig, 1, s, "base64")
+}
+
+/**
+ * hexEncode(s); lowercase hexadecimal encoding
+ */
+func builtInHexEncode(state *State, s interface{}) (string, error) {
+	const sig = "hexEncode(string)"
+	b, err := bytesParam(sig, 1, s)
+	if err != nil {
+		return "", err
+	}
+	// the result is checked before it is produced, since it may be huge
+	if len(b) > math.MaxInt32/2 {
+		return "", invalidParameterError(sig, 1, s, "shorter string or []byte")
+	} else if err := checkStringLength(state, hex.EncodedLen(len(b))); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/**
+ * urlEncode(s); the string escaped so that it can be used in a query
+ */
+func builtInURLEncode(s interface{}) (string, error) {
+	p, err := stringParams("urlEncode(string)", s)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryEscape(p[0]), nil
+}
+
+/**
+ * urlDecode(s); the inverse of urlEncode
+ */
+func builtInURLDecode(s interface{}) (string, error) {
+	const sig = "urlDecode(string)"
+	p, err := stringParams(sig, s)
+	if err != nil {
+		return "", err
+	}
+	v, err := url.QueryUnescape(p[0])
+	if err != nil {
+		return "", invalidParameterValueError(sig, 1, s, "URL-encoded string")
+	}
+	return v, nil
+}
+
+/**
+ * sha256(s); the SHA-256 digest, in lowercase hexadecimal
+ */
+func builtInSHA256(s interface{}) (string, error) {
+	b, err := bytesParam("sha256(string)", 1, s)
+	if err != nil {
+		return "", err
+	}
+	d := sha256.Sum256(b)
+	return hex.EncodeToString(d[:]), nil
+}
+
+/**
+ * fnv64(s); the 64-bit FNV-1a hash, which is stable and suitable for
+ * bucketing, like fnv64(user.ID + "experiment") % 100 < 10. The modulo
+ * operator is exact for the full range of the hash.
+ */
+func builtInFNV64(s interface{}) (uint64, error) {
+	b, err := bytesParam("fnv64(string)", 1, s)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), nil
+}
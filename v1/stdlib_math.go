@@ -0,0 +1,212 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"math"
+	"reflect"
+)
+
+/**
+ * A function of one number
+ */
+func mathFunc1(sig string, x interface{}, f func(float64) float64) (float64, error) {
+	p, err := numberParams(sig, x)
+	if err != nil {
+		return 0, err
+	}
+	return f(p[0]), nil
+}
+
+/**
+ * abs(x)
+ */
+func builtInAbs(x interface{}) (float64, error) {
+	return mathFunc1("abs(number)", x, math.Abs)
+}
+
+/**
+ * floor(x)
+ */
+func builtInFloor(x interface{}) (float64, error) {
+	return mathFunc1("floor(number)", x, math.Floor)
+}
+
+/**
+ * ceil(x)
+ */
+func builtInCeil(x interface{}) (float64, error) {
+	return mathFunc1("ceil(number)", x, math.Ceil)
+}
+
+/**
+ * sqrt(x); the square root of a negative number is NaN
+ */
+func builtInSqrt(x interface{}) (float64, error) {
+	return mathFunc1("sqrt(number)", x, math.Sqrt)
+}
+
+/**
+ * log(x); the natural logarithm
+ */
+func builtInLog(x interface{}) (float64, error) {
+	return mathFunc1("log(number)", x, math.Log)
+}
+
+/**
+ * exp(x)
+ */
+func builtInExp(x interface{}) (float64, error) {
+	return mathFunc1("exp(number)", x, math.Exp)
+}
+
+/**
+ * pow(x, y)
+ */
+func builtInPow(x, y interface{}) (float64, error) {
+	p, err := numberParams("pow(number, number)", x, y)
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(p[0], p[1]), nil
+}
+
+/**
+ * round(x[, places]); half is rounded away from zero. When places is
+ * provided the number is rounded to that many decimal places, or to a
+ * power of ten if it is negative.
+ */
+func builtInRound(x interface{}, places ...interface{}) (float64, error) {
+	const sig = "round(number[, int])"
+	if len(places) > 1 {
+		return 0, invalidParameterCountError(sig, 1+len(places))
+	}
+	p, err := numberParams(sig, x)
+	if err != nil {
+		return 0, err
+	}
+	if len(places) < 1 {
+		return math.Round(p[0]), nil
+	}
+	n, err := intParam(sig, 2, places[0])
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		m := math.Pow10(n)
+		if r := math.Round(p[0]*m) / m; !math.IsInf(r, 0) && !math.IsNaN(r) {
+			return r, nil
+		}
+		return p[0], nil // there are not so many places to round to
+	}
+	m := math.Pow10(-n)
+	if math.IsInf(m, 0) && !math.IsInf(p[0], 0) {
+		return 0, nil // the power of ten is greater than any finite number
+	}
+	return math.Round(p[0]/m) * m, nil
+}
+
+/**
+ * min(...x); the least of the numbers, which may also be provided as a list
+ */
+func builtInMin(x ...interface{}) (float64, error) {
+	return extremum("min(number, ...number)", x, math.Min)
+}
+
+/**
+ * max(...x); the greatest of the numbers, which may also be provided as a list
+ */
+func builtInMax(x ...interface{}) (float64, error) {
+	return extremum("max(number, ...number)", x, math.Max)
+}
+
+/**
+ * Reduce numbers, or a list of numbers, to the extremum selected by f
+ */
+func extremum(sig string, x []interface{}, f func(float64, float64) float64) (float64, error) {
+	if len(x) == 1 {
+		if v, _ := derefValue(reflect.ValueOf(x[0])); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			x = make([]interface{}, v.Len())
+			for i := range x {
+				x[i] = v.Index(i).Interface()
+			}
+		}
+	}
+	if len(x) < 1 {
+		return 0, invalidParameterCountError(sig, 0)
+	}
+	p, err := numberParams(sig, x...)
+	if err != nil {
+		return 0, err
+	}
+	r := p[0]
+	for _, e := range p[1:] {
+		r = f(r, e)
+	}
+	return r, nil
+}
+
+/**
+ * clamp(x, min, max)
+ */
+func builtInClamp(x, lo, hi interface{}) (float64, error) {
+	const sig = "clamp(number, number, number)"
+	p, err := numberParams(sig, x, lo, hi)
+	if err != nil {
+		return 0, err
+	}
+	if p[1] > p[2] {
+		return 0, invalidParameterValueError(sig, 3, hi, "number no less than the minimum")
+	}
+	return math.Max(p[1], math.Min(p[2], p[0])), nil
+}
+
+/**
+ * isNaN(x)
+ */
+func builtInIsNaN(x interface{}) (bool, error) {
+	p, err := numberParams("isNaN(number)", x)
+	if err != nil {
+		return false, err
+	}
+	return math.IsNaN(p[0]), nil
+}
+
+/**
+ * isInf(x); either positive or negative infinity
+ */
+func builtInIsInf(x interface{}) (bool, error) {
+	p, err := numberParams("isInf(number)", x)
+	if err != nil {
+		return false, err
+	}
+	return math.IsInf(p[0], 0), nil
+}
@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+/**
+ * Obtain an IP address from an operand, which may be an address or a
+ * string which is parsed as one
+ */
+func addrOperand(v interface{}) (netip.Addr, bool) {
+	switch c := orderedValue(v).(type) {
+	case netip.Addr:
+		return c, c.IsValid()
+	case string:
+		a, err := netip.ParseAddr(c)
+		return a, err == nil
+	}
+	return netip.Addr{}, false
+}
+
+/**
+ * Obtain the IP address parameter of a builtin
+ */
+func addrParam(sig string, i int, v interface{}) (netip.Addr, error) {
+	if s, ok := v.(string); ok {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Addr{}, invalidParameterValueError(sig, i, v, "IP address")
+		}
+		return a, nil
+	}
+	a, ok := addrOperand(v)
+	if !ok {
+		return netip.Addr{}, invalidParameterError(sig, i, v, "IP address or string")
+	}
+	return a, nil
+}
+
+/**
+ * ip(s); an IP address. A string may include a port, like the remote
+ * address of a request, which is discarded.
+ */
+func builtInIP(s interface{}) (netip.Addr, error) {
+	const sig = "ip(string)"
+	if v, ok := s.(string); ok {
+		if a, err := netip.ParseAddr(v); err == nil {
+			return a, nil
+		}
+		if p, err := netip.ParseAddrPort(v); err == nil {
+			return p.Addr(), nil
+		}
+		return netip.Addr{}, invalidParameterValueError(sig, 1, s, "IP address")
+	}
+	return addrParam(sig, 1, s)
+}
+
+/**
+ * cidr(s); a network, like "10.0.0.0/8". Bits of the address which are
+ * not in the prefix are ignored.
+ */
+func builtInCIDR(s interface{}) (netip.Prefix, error) {
+	const sig = "cidr(string)"
+	switch v := s.(type) {
+	case netip.Prefix:
+		return v.Masked(), nil
+	case *net.IPNet:
+		if v != nil {
+			if p, err := netip.ParsePrefix(v.String()); err == nil {
+				return p.Masked(), nil
+			}
+		}
+	case string:
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return netip.Prefix{}, invalidParameterValueError(sig, 1, s, "network in CIDR notation")
+		}
+		return p.Masked(), nil
+	}
+	return netip.Prefix{}, invalidParameterError(sig, 1, s, "string")
+}
+
+/**
+ * isPrivateIP(x); the address is in a private network, as described by
+ * RFC 1918 or RFC 4193
+ */
+func builtInIsPrivateIP(x interface{}) (bool, error) {
+	a, err := addrParam("isPrivateIP(ip)", 1, x)
+	if err != nil {
+		return false, err
+	}
+	return a.Unmap().IsPrivate(), nil
+}
+
+/**
+ * isIPv6(x); the address is an IPv6 address which is not an IPv4-mapped
+ * address
+ */
+func builtInIsIPv6(x interface{}) (bool, error) {
+	a, err := addrParam("isIPv6(ip)", 1, x)
+	if err != nil {
+		return false, err
+	}
+	return a.Is6() && !a.Is4In6(), nil
+}
+
+/**
+ * hostMatch(pattern, host); the host name matches the pattern, which is
+ * compared label by label without regard to case. A label of the pattern
+ * may use wildcards as described by path.Match, which never match across
+ * labels; so "*.example.com" matches "api.example.com" but neither
+ * "example.com" nor "a.b.example.com". A port on the host is ignored.
+ */
+func builtInHostMatch(pattern, host interface{}) (bool, error) {
+	const sig = "hostMatch(string, string)"
+	p, err := stringParams(sig, pattern, host)
+	if err != nil {
+		return false, err
+	}
+	h := p[1]
+	if v, _, err := net.SplitHostPort(h); err == nil {
+		h = v
+	}
+	pl := strings.Split(strings.ToLower(strings.TrimSuffix(p[0], ".")), ".")
+	hl := strings.Split(strings.ToLower(strings.TrimSuffix(h, ".")), ".")
+	for _, e := range pl {
+		if _, err := path.Match(e, ""); err != nil {
+			return false, invalidParameterValueError(sig, 1, pattern, "valid pattern")
+		}
+	}
+	if len(pl) != len(hl) {
+		return false, nil
+	}
+	for i, e := range pl {
+		if m, _ := path.Match(e, hl[i]); !m || hl[i] == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
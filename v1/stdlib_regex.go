@@ -0,0 +1,261 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+/**
+ * The maximum number of compiled expressions retained by the cache
+ */
+const regexCacheSize = 256
+
+/**
+ * Compiled expressions, shared by every program
+ */
+var regexes = newRegexCache(regexCacheSize)
+
+/**
+ * A bounded cache of compiled regular expressions. When the cache is full
+ * the least recently used expression is evicted. A compiled expression is
+ * safe for concurrent use, so the same one is shared by every caller.
+ */
+type regexCache struct {
+	sync.Mutex
+	max   int
+	index map[string]*list.Element
+	order *list.List // most recently used first; values are *regexp.Regexp
+}
+
+/**
+ * Create a cache which retains at most max expressions
+ */
+func newRegexCache(max int) *regexCache {
+	return &regexCache{max: max, index: make(map[string]*list.Element), order: list.New()}
+}
+
+/**
+ * Obtain the compiled expression for a pattern, compiling it if it is not
+ * already cached. Invalid patterns are not cached.
+ */
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.Lock()
+	if e, ok := c.index[pattern]; ok {
+		c.order.MoveToFront(e)
+		c.Unlock()
+		return e.Value.(*regexp.Regexp), nil
+	}
+	c.Unlock()
+
+	// compile without holding the lock; if another caller compiles the same
+	// pattern in the meantime, the first one cached is kept
+	x, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.index[pattern]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexp.Regexp), nil
+	}
+	c.index[pattern] = c.order.PushFront(x)
+	for c.order.Len() > c.max {
+		e := c.order.Back()
+		delete(c.index, e.Value.(*regexp.Regexp).String())
+		c.order.Remove(e)
+	}
+	return x, nil
+}
+
+/**
+ * The builtins which take a pattern as their first parameter
+ */
+var regexBuiltins = map[string]bool{
+	"match":        true,
+	"find":         true,
+	"findAll":      true,
+	"submatch":     true,
+	"replaceRegex": true,
+}
+
+/**
+ * Check the literal patterns provided to regex builtins, producing an error
+ * for each which cannot be compiled. An invocation is only checked if the
+ * name refers to the builtin in the standard library provided; a library
+ * function of the same name takes precedence, as it does when executed.
+ *
+ * The context a program is executed with is not known when it is compiled,
+ * so a function of the same name in the context does not prevent the check.
+ * A host which replaces a regex builtin with a function that accepts other
+ * patterns provides it in a library or excludes the builtin with
+ * StdlibOptions instead.
+ */
+func checkPatterns(x executable, lib map[string]interface{}, env functionEnv) ErrorList {
+	switch n := x.(type) {
+	case *logicalOrNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *logicalAndNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *arithmeticNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *relationalNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *indexNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *derefNode:
+		return checkPatternList(lib, env, n.left, n.right)
+	case *invokeNode:
+		var errs ErrorList
+		if ident, ok := n.right.(*identNode); ok && n.left == nil && len(n.params) > 0 && regexBuiltins[ident.ident] && env[ident.ident] == nil && lib[ident.ident] != nil {
+			if lit, ok := n.params[0].(*literalNode); ok {
+				if pattern, ok := lit.value.(string); ok {
+					if _, err := regexes.compile(pattern); err != nil {
+						errs = append(errs, newError(KindCompile, lit.span, err, fmt.Sprintf("Invalid pattern to: %v", ident.ident)))
+					}
+				}
+			}
+		}
+		return append(errs, checkPatternList(lib, env, n.params...)...)
+	default:
+		return nil
+	}
+}
+
+/**
+ * Check the patterns in a list of expressions
+ */
+func checkPatternList(lib map[string]interface{}, env functionEnv, l ...executable) ErrorList {
+	var errs ErrorList
+	for _, x := range l {
+		errs = append(errs, checkPatterns(x, lib, env)...)
+	}
+	return errs
+}
+
+/**
+ * Obtain the compiled expression and the operand of a regex builtin
+ */
+func regexParams(sig string, pattern, s interface{}) (*regexp.Regexp, string, error) {
+	p, err := stringParams(sig, pattern, s)
+	if err != nil {
+		return nil, "", err
+	}
+	x, err := regexes.compile(p[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return x, p[1], nil
+}
+
+/**
+ * find(pattern, s); the first match of the pattern in s, or the empty
+ * string if there is none
+ */
+func builtInFind(pattern, s interface{}) (string, error) {
+	x, v, err := regexParams("find(string, string)", pattern, s)
+	if err != nil {
+		return "", err
+	}
+	return x.FindString(v), nil
+}
+
+/**
+ * findAll(pattern, s[, n]); every match of the pattern in s, unless n is
+ * provided, in which case at most the first n
+ */
+func builtInFindAll(pattern, s interface{}, n ...interface{}) ([]string, error) {
+	const sig = "findAll(string, string[, int])"
+	if len(n) > 1 {
+		return nil, invalidParameterCountError(sig, 2+len(n))
+	}
+	x, v, err := regexParams(sig, pattern, s)
+	if err != nil {
+		return nil, err
+	}
+	c := -1
+	if len(n) > 0 {
+		if c, err = intParam(sig, 3, n[0]); err != nil {
+			return nil, err
+		}
+	}
+	m := x.FindAllString(v, c)
+	if m == nil {
+		return []string{}, nil
+	}
+	return m, nil
+}
+
+/**
+ * submatch(pattern, s); the named groups of the first match of the pattern
+ * in s, by name, or nil if there is no match. A group which does not
+ * participate in the match is not present.
+ */
+func builtInSubmatch(pattern, s interface{}) (map[string]string, error) {
+	x, v, err := regexParams("submatch(string, string)", pattern, s)
+	if err != nil {
+		return nil, err
+	}
+	m := x.FindStringSubmatchIndex(v)
+	if m == nil {
+		return nil, nil
+	}
+	g := make(map[string]string)
+	for i, name := range x.SubexpNames() {
+		if name != "" && m[2*i] >= 0 {
+			g[name] = v[m[2*i]:m[2*i+1]]
+		}
+	}
+	return g, nil
+}
+
+/**
+ * replaceRegex(pattern, s, repl); every match of the pattern in s is
+ * replaced, with references like $1 or ${name} in repl expanded to the
+ * corresponding group
+ */
+func builtInReplaceRegex(pattern, s, repl interface{}) (string, error) {
+	const sig = "replaceRegex(string, string, string)"
+	x, v, err := regexParams(sig, pattern, s)
+	if err != nil {
+		return "", err
+	}
+	r, ok := repl.(string)
+	if !ok {
+		return "", invalidParameterError(sig, 3, repl, "string")
+	}
+	return x.ReplaceAllString(v, r), nil
+}
@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+/**
+ * Obtain a version from an operand, which may be a version or a string
+ * which is parsed as one
+ */
+func versionOperand(v interface{}) (Version, bool) {
+	switch c := orderedValue(v).(type) {
+	case Version:
+		return c, true
+	case string:
+		x, err := ParseVersion(c)
+		return x, err == nil
+	}
+	return Version{}, false
+}
+
+/**
+ * Obtain the version parameter of a builtin
+ */
+func versionParam(sig string, i int, v interface{}) (Version, error) {
+	x, ok := versionOperand(v)
+	if ok {
+		return x, nil
+	}
+	if _, ok := v.(string); ok {
+		return Version{}, invalidParameterValueError(sig, i, v, "semantic version")
+	}
+	return Version{}, invalidParameterError(sig, i, v, "version or string")
+}
+
+/**
+ * semver(s); a semantic version, like "2.3.0" or "v1.0.0-beta.2"
+ */
+func builtInSemver(s interface{}) (Version, error) {
+	return versionParam("semver(string)", 1, s)
+}
+
+/**
+ * satisfies(v, constraint); the version satisfies the constraint, like
+ * ">=1.2 <2.0" or "^1.4 || ~2.0.1"
+ */
+func builtInSatisfies(v, constraint interface{}) (bool, error) {
+	const sig = "satisfies(version, string)"
+	x, err := versionParam(sig, 1, v)
+	if err != nil {
+		return false, err
+	}
+	s, ok := constraint.(string)
+	if !ok {
+		return false, invalidParameterError(sig, 2, constraint, "string")
+	}
+	c, err := parseVersionConstraint(s)
+	if err != nil {
+		return false, invalidParameterValueError(sig, 2, constraint, "version constraint")
+	}
+	return c.match(x), nil
+}
@@ -0,0 +1,320 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+/**
+ * contains(s, substr)
+ */
+func builtInContains(s, substr interface{}) (bool, error) {
+	p, err := stringParams("contains(string, string)", s, substr)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(p[0], p[1]), nil
+}
+
+/**
+ * hasPrefix(s, prefix)
+ */
+func builtInHasPrefix(s, prefix interface{}) (bool, error) {
+	p, err := stringParams("hasPrefix(string, string)", s, prefix)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(p[0], p[1]), nil
+}
+
+/**
+ * hasSuffix(s, suffix)
+ */
+func builtInHasSuffix(s, suffix interface{}) (bool, error) {
+	p, err := stringParams("hasSuffix(string, string)", s, suffix)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasSuffix(p[0], p[1]), nil
+}
+
+/**
+ * lower(s)
+ */
+func builtInLower(s interface{}) (string, error) {
+	p, err := stringParams("lower(string)", s)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(p[0]), nil
+}
+
+/**
+ * upper(s)
+ */
+func builtInUpper(s interface{}) (string, error) {
+	p, err := stringParams("upper(string)", s)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(p[0]), nil
+}
+
+/**
+ * title(s); the first letter of each word is converted to title case
+ */
+func builtInTitle(s interface{}) (string, error) {
+	p, err := stringParams("title(string)", s)
+	if err != nil {
+		return "", err
+	}
+	w := false // within a word
+	return strings.Map(func(r rune) rune {
+		l := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '\'' || r == '_'
+		if l && !w {
+			r = unicode.ToTitle(r)
+		}
+		w = l
+		return r
+	}, p[0]), nil
+}
+
+/**
+ * trim(s[, cutset]); leading and trailing whitespace is removed unless a
+ * cutset is provided, in which case those characters are removed
+ */
+func builtInTrim(s interface{}, cutset ...interface{}) (string, error) {
+	return trimString("trim(string[, string])", s, cutset, strings.TrimSpace, strings.Trim)
+}
+
+/**
+ * trimLeft(s[, cutset])
+ */
+func builtInTrimLeft(s interface{}, cutset ...interface{}) (string, error) {
+	return trimString("trimLeft(string[, string])", s, cutset, func(v string) string {
+		return strings.TrimLeftFunc(v, unicode.IsSpace)
+	}, strings.TrimLeft)
+}
+
+/**
+ * trimRight(s[, cutset])
+ */
+func builtInTrimRight(s interface{}, cutset ...interface{}) (string, error) {
+	return trimString("trimRight(string[, string])", s, cutset, func(v string) string {
+		return strings.TrimRightFunc(v, unicode.IsSpace)
+	}, strings.TrimRight)
+}
+
+/**
+ * Trim a string with or without a cutset
+ */
+func trimString(sig string, s interface{}, cutset []interface{}, space func(string) string, cut func(string, string) string) (string, error) {
+	if len(cutset) > 1 {
+		return "", invalidParameterCountError(sig, 1+len(cutset))
+	}
+	p, err := stringParams(sig, append([]interface{}{s}, cutset...)...)
+	if err != nil {
+		return "", err
+	}
+	if len(p) < 2 {
+		return space(p[0]), nil
+	}
+	return cut(p[0], p[1]), nil
+}
+
+/**
+ * trimPrefix(s, prefix)
+ */
+func builtInTrimPrefix(s, prefix interface{}) (string, error) {
+	p, err := stringParams("trimPrefix(string, string)", s, prefix)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(p[0], p[1]), nil
+}
+
+/**
+ * trimSuffix(s, suffix)
+ */
+func builtInTrimSuffix(s, suffix interface{}) (string, error) {
+	p, err := stringParams("trimSuffix(string, string)", s, suffix)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(p[0], p[1]), nil
+}
+
+/**
+ * split(s, sep)
+ */
+func builtInSplit(s, sep interface{}) ([]string, error) {
+	p, err := stringParams("split(string, string)", s, sep)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(p[0], p[1]), nil
+}
+
+/**
+ * fields(s); the string is split around runs of whitespace
+ */
+func builtInFields(s interface{}) ([]string, error) {
+	p, err := stringParams("fields(string)", s)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(p[0]), nil
+}
+
+/**
+ * join(list, sep); every element of the list must be a string
+ */
+func builtInJoin(list, sep interface{}) (string, error) {
+	const sig = "join(list, string)"
+	ss, ok := sep.(string)
+	if !ok {
+		return "", invalidParameterError(sig, 2, sep, "string")
+	}
+	if l, ok := list.([]string); ok {
+		return strings.Join(l, ss), nil
+	}
+	v, _ := derefValue(reflect.ValueOf(list))
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+	default:
+		return "", invalidParameterError(sig, 1, list, "list")
+	}
+	e := make([]string, v.Len())
+	for i := range e {
+		x := v.Index(i).Interface()
+		if e[i], ok = x.(string); !ok {
+			return "", fmt.Errorf("Invalid parameter to: %s (element %d of parameter 1 is %v, expected string)", sig, i, displayType(reflect.ValueOf(x)))
+		}
+	}
+	return strings.Join(e, ss), nil
+}
+
+/**
+ * replace(s, old, repl[, n]); every instance of old is replaced unless n is
+ * provided, in which case at most the first n are
+ */
+func builtInReplace(state *State, s, old, repl interface{}, n ...interface{}) (string, error) {
+	const sig = "replace(string, string, string[, int])"
+	if len(n) > 1 {
+		return "", invalidParameterCountError(sig, 3+len(n))
+	}
+	p, err := stringParams(sig, s, old, repl)
+	if err != nil {
+		return "", err
+	}
+	c := -1
+	if len(n) > 0 {
+		if c, err = intParam(sig, 4, n[0]); err != nil {
+			return "", err
+		}
+	}
+	// the result is checked before it is produced, since it may be huge
+	if d := len(p[2]) - len(p[1]); d > 0 {
+		m := strings.Count(p[0], p[1])
+		if c >= 0 && c < m {
+			m = c
+		}
+		if m > (math.MaxInt32-len(p[0]))/d {
+			return "", invalidParameterError(sig, 3, repl, "shorter string")
+		} else if err := checkStringLength(state, len(p[0])+m*d); err != nil {
+			return "", err
+		}
+	}
+	return strings.Replace(p[0], p[1], p[2], c), nil
+}
+
+/**
+ * index(s, substr); the byte offset of the first instance of substr in s,
+ * or -1 if it is not present
+ */
+func builtInIndex(s, substr interface{}) (int, error) {
+	p, err := stringParams("index(string, string)", s, substr)
+	if err != nil {
+		return 0, err
+	}
+	return strings.Index(p[0], p[1]), nil
+}
+
+/**
+ * repeat(s, n)
+ */
+func builtInRepeat(state *State, s, n interface{}) (string, error) {
+	const sig = "repeat(string, int)"
+	p, err := stringParams(sig, s)
+	if err != nil {
+		return "", err
+	}
+	c, err := intParam(sig, 2, n)
+	if err != nil {
+		return "", err
+	} else if c < 0 {
+		return "", invalidParameterValueError(sig, 2, n, "non-negative int")
+	}
+	// the result is checked before it is produced, since it may be huge
+	if l := len(p[0]); l > 0 && c > math.MaxInt32/l {
+		return "", invalidParameterValueError(sig, 2, n, "smaller int")
+	} else if err := checkStringLength(state, l*c); err != nil {
+		return "", err
+	}
+	return strings.Repeat(p[0], c), nil
+}
+
+/**
+ * Check the length of a string which a builtin is about to produce against
+ * the limit on the length of strings, if any
+ */
+func checkStringLength(state *State, n int) error {
+	if max := state.Runtime.limits.MaxStringLength; max > 0 && n > max {
+		return &LimitError{LimitStringLength, max}
+	}
+	return nil
+}
+
+/**
+ * equalFold(a, b); the strings are compared without regard to case
+ */
+func builtInEqualFold(a, b interface{}) (bool, error) {
+	p, err := stringParams("equalFold(string, string)", a, b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(p[0], p[1]), nil
+}
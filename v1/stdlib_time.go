@@ -0,0 +1,280 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+ * Layouts which can be referred to by name when parsing or formatting a
+ * time, as they are in the Go time package
+ */
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    "2006-01-02 15:04:05",
+	"DateOnly":    "2006-01-02",
+	"TimeOnly":    "15:04:05",
+}
+
+/**
+ * Locations which have been loaded, by name
+ */
+var timeLocations sync.Map
+
+/**
+ * Obtain a parameter which must be a time
+ */
+func timeParam(sig string, i int, v interface{}) (time.Time, error) {
+	switch c := v.(type) {
+	case time.Time:
+		return c, nil
+	case *time.Time:
+		if c != nil {
+			return *c, nil
+		}
+	}
+	return time.Time{}, invalidParameterError(sig, i, v, "time.Time")
+}
+
+/**
+ * Obtain a layout parameter, which is either a layout or the name of one
+ */
+func layoutParam(sig string, i int, v interface{}) (string, error) {
+	l, ok := v.(string)
+	if !ok {
+		return "", invalidParameterError(sig, i, v, "string")
+	}
+	if n, ok := timeLayouts[l]; ok {
+		return n, nil
+	}
+	return l, nil
+}
+
+/**
+ * now(); the current time, as reported by the runtime's clock
+ */
+func builtInNow(state *State) time.Time {
+	return state.Runtime.Clock.Now()
+}
+
+/**
+ * since(t); the duration elapsed since a time, as reported by the
+ * runtime's clock
+ */
+func builtInSince(state *State, t interface{}) (time.Duration, error) {
+	v, err := timeParam("since(time.Time)", 1, t)
+	if err != nil {
+		return 0, err
+	}
+	return state.Runtime.Clock.Now().Sub(v), nil
+}
+
+/**
+ * date(year, month, day); midnight UTC on a date
+ */
+func builtInDate(year, month, day interface{}) (time.Time, error) {
+	const sig = "date(int, int, int)"
+	var p [3]int
+	for i, e := range []interface{}{year, month, day} {
+		var err error
+		if p[i], err = intParam(sig, i+1, e); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Date(p[0], time.Month(p[1]), p[2], 0, 0, 0, 0, time.UTC), nil
+}
+
+/**
+ * duration(s); parse a duration, like "90m" or "1h30m"
+ */
+func builtInDuration(s interface{}) (time.Duration, error) {
+	p, err := stringParams("duration(string)", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(p[0])
+}
+
+/**
+ * parseTime(layout, s)
+ */
+func builtInParseTime(layout, s interface{}) (time.Time, error) {
+	const sig = "parseTime(string, string)"
+	l, err := layoutParam(sig, 1, layout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	p, err := stringParams(sig, l, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(l, p[1])
+}
+
+/**
+ * format(t, layout)
+ */
+func builtInFormat(t, layout interface{}) (string, error) {
+	const sig = "format(time.Time, string)"
+	v, err := timeParam(sig, 1, t)
+	if err != nil {
+		return "", err
+	}
+	l, err := layoutParam(sig, 2, layout)
+	if err != nil {
+		return "", err
+	}
+	return v.Format(l), nil
+}
+
+/**
+ * A component of a time
+ */
+func timeComponent(sig string, t interface{}, f func(time.Time) int) (int, error) {
+	v, err := timeParam(sig, 1, t)
+	if err != nil {
+		return 0, err
+	}
+	return f(v), nil
+}
+
+/**
+ * year(t)
+ */
+func builtInYear(t interface{}) (int, error) {
+	return timeComponent("year(time.Time)", t, time.Time.Year)
+}
+
+/**
+ * month(t); from 1 (January) to 12
+ */
+func builtInMonth(t interface{}) (int, error) {
+	return timeComponent("month(time.Time)", t, func(v time.Time) int { return int(v.Month()) })
+}
+
+/**
+ * day(t); the day of the month
+ */
+func builtInDay(t interface{}) (int, error) {
+	return timeComponent("day(time.Time)", t, time.Time.Day)
+}
+
+/**
+ * hour(t)
+ */
+func builtInHour(t interface{}) (int, error) {
+	return timeComponent("hour(time.Time)", t, time.Time.Hour)
+}
+
+/**
+ * minute(t)
+ */
+func builtInMinute(t interface{}) (int, error) {
+	return timeComponent("minute(time.Time)", t, time.Time.Minute)
+}
+
+/**
+ * weekday(t); the name of the day of the week, like "Monday"
+ */
+func builtInWeekday(t interface{}) (string, error) {
+	v, err := timeParam("weekday(time.Time)", 1, t)
+	if err != nil {
+		return "", err
+	}
+	return v.Weekday().String(), nil
+}
+
+/**
+ * startOf(t, unit); the beginning of the minute, hour, day, week, month,
+ * or year which contains a time, in its location. Weeks begin on Monday.
+ */
+func builtInStartOf(t, unit interface{}) (time.Time, error) {
+	const sig = "startOf(time.Time, string)"
+	v, err := timeParam(sig, 1, t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	u, ok := unit.(string)
+	if !ok {
+		return time.Time{}, invalidParameterError(sig, 2, unit, "string")
+	}
+	y, m, d := v.Date()
+	switch u {
+	case "minute":
+		return time.Date(y, m, d, v.Hour(), v.Minute(), 0, 0, v.Location()), nil
+	case "hour":
+		return time.Date(y, m, d, v.Hour(), 0, 0, 0, v.Location()), nil
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, v.Location()), nil
+	case "week":
+		return time.Date(y, m, d-(int(v.Weekday())+6)%7, 0, 0, 0, 0, v.Location()), nil
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, v.Location()), nil
+	case "year":
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, v.Location()), nil
+	default:
+		return time.Time{}, invalidParameterValueError(sig, 2, unit, `one of "minute", "hour", "day", "week", "month", or "year"`)
+	}
+}
+
+/**
+ * inZone(t, name); a time in the named location, like "America/New_York"
+ */
+func builtInInZone(t, name interface{}) (time.Time, error) {
+	const sig = "inZone(time.Time, string)"
+	v, err := timeParam(sig, 1, t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	n, ok := name.(string)
+	if !ok {
+		return time.Time{}, invalidParameterError(sig, 2, name, "string")
+	}
+	if l, ok := timeLocations.Load(n); ok {
+		return v.In(l.(*time.Location)), nil
+	}
+	l, err := time.LoadLocation(n)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timeLocations.Store(n, l)
+	return v.In(l), nil
+}
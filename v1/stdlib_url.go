@@ -0,0 +1,200 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+/**
+ * A URL, as produced by url(). The components are those of a net/url.URL,
+ * with the host and port separated.
+ */
+type URL struct {
+	Scheme   string
+	Host     string // the host name or IP address, without a port or brackets
+	Port     string // the port, if one is specified
+	Path     string
+	Query    url.Values
+	Fragment string
+}
+
+/**
+ * Create a URL from a parsed net/url.URL
+ */
+func newURL(u *url.URL) URL {
+	return URL{
+		Scheme:   u.Scheme,
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		Path:     u.Path,
+		Query:    u.Query(),
+		Fragment: u.Fragment,
+	}
+}
+
+/**
+ * Format a URL
+ */
+func (u URL) String() string {
+	h := u.Host
+	if u.Port != "" || strings.Contains(h, ":") {
+		h = net.JoinHostPort(h, u.Port)
+		if u.Port == "" {
+			h = strings.TrimSuffix(h, ":")
+		}
+	}
+	x := url.URL{Scheme: u.Scheme, Host: h, Path: u.Path, RawQuery: u.Query.Encode(), Fragment: u.Fragment}
+	return x.String()
+}
+
+/**
+ * An email address, as produced by email()
+ */
+type Email struct {
+	Name    string // the display name, if one is provided
+	Address string // the address itself, like "user@example.com"
+	Local   string // the local part, which precedes '@'
+	Domain  string // the domain, in lowercase
+}
+
+/**
+ * Obtain a URL from an operand, which may be a URL, a net/url.URL, or a
+ * string which is parsed as one
+ */
+func urlParam(sig string, i int, v interface{}) (URL, error) {
+	switch c := v.(type) {
+	case URL:
+		return c, nil
+	case *URL:
+		if c != nil {
+			return *c, nil
+		}
+	case url.URL:
+		return newURL(&c), nil
+	case *url.URL:
+		if c != nil {
+			return newURL(c), nil
+		}
+	case string:
+		u, err := url.Parse(c)
+		if err != nil {
+			return URL{}, invalidParameterValueError(sig, i, v, "URL")
+		}
+		return newURL(u), nil
+	}
+	return URL{}, invalidParameterError(sig, i, v, "URL or string")
+}
+
+/**
+ * url(s); a URL, which can be dereferenced to obtain its Scheme, Host,
+ * Port, Path, Query, and Fragment
+ */
+func builtInURL(s interface{}) (URL, error) {
+	return urlParam("url(string)", 1, s)
+}
+
+/**
+ * query(u, key); the first value of a query parameter of the URL, or the
+ * empty string if it is not present
+ */
+func builtInQuery(u, key interface{}) (string, error) {
+	const sig = "query(url, string)"
+	x, err := urlParam(sig, 1, u)
+	if err != nil {
+		return "", err
+	}
+	k, ok := key.(string)
+	if !ok {
+		return "", invalidParameterError(sig, 2, key, "string")
+	}
+	return x.Query.Get(k), nil
+}
+
+/**
+ * isURL(s); the string is an absolute URL, with a scheme and host
+ */
+func builtInIsURL(s interface{}) (bool, error) {
+	p, err := stringParams("isURL(string)", s)
+	if err != nil {
+		return false, err
+	}
+	u, err := url.Parse(p[0])
+	return err == nil && u.Scheme != "" && u.Hostname() != "", nil
+}
+
+/**
+ * Parse an email address, which may include a display name
+ */
+func parseEmail(s string) (Email, bool) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return Email{}, false
+	}
+	i := strings.LastIndexByte(a.Address, '@')
+	if i < 0 {
+		return Email{}, false
+	}
+	return Email{Name: a.Name, Address: a.Address, Local: a.Address[:i], Domain: strings.ToLower(a.Address[i+1:])}, true
+}
+
+/**
+ * email(s); an email address, like "user@example.com" or
+ * "User <user@example.com>", which can be dereferenced to obtain its
+ * Name, Address, Local part, and Domain
+ */
+func builtInEmail(s interface{}) (Email, error) {
+	const sig = "email(string)"
+	p, err := stringParams(sig, s)
+	if err != nil {
+		return Email{}, err
+	}
+	e, ok := parseEmail(p[0])
+	if !ok {
+		return Email{}, invalidParameterValueError(sig, 1, s, "email address")
+	}
+	return e, nil
+}
+
+/**
+ * isEmail(s); the string is an email address alone, without a display name
+ */
+func builtInIsEmail(s interface{}) (bool, error) {
+	p, err := stringParams("isEmail(string)", s)
+	if err != nil {
+		return false, err
+	}
+	e, ok := parseEmail(p[0])
+	return ok && e.Name == "" && e.Address == p[0], nil
+}
@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+/**
+ * Obtain a UUID from an operand, which may be a UUID or a string which is
+ * parsed as one
+ */
+func uuidOperand(v interface{}) (UUID, bool) {
+	switch c := orderedValue(v).(type) {
+	case UUID:
+		return c, true
+	case string:
+		u, err := ParseUUID(c)
+		return u, err == nil
+	}
+	return UUID{}, false
+}
+
+/**
+ * Obtain the UUID parameter of a builtin
+ */
+func uuidParam(sig string, i int, v interface{}) (UUID, error) {
+	u, ok := uuidOperand(v)
+	if ok {
+		return u, nil
+	}
+	if _, ok := v.(string); ok {
+		return UUID{}, invalidParameterValueError(sig, i, v, "UUID")
+	}
+	return UUID{}, invalidParameterError(sig, i, v, "UUID or string")
+}
+
+/**
+ * uuid(s); a UUID, like "9515976f-cdb4-4e56-bd07-b1ae6efc00da"
+ */
+func builtInUUID(s interface{}) (UUID, error) {
+	return uuidParam("uuid(string)", 1, s)
+}
+
+/**
+ * isUUID(x); the value is a UUID or a string which is a valid UUID
+ */
+func builtInIsUUID(x interface{}) bool {
+	_, ok := uuidOperand(x)
+	return ok
+}
+
+/**
+ * uuidVersion(x)
+ */
+func builtInUUIDVersion(x interface{}) (int, error) {
+	u, err := uuidParam("uuidVersion(uuid)", 1, x)
+	if err != nil {
+		return 0, err
+	}
+	return u.Version(), nil
+}
+
+/**
+ * uuidVariant(x)
+ */
+func builtInUUIDVariant(x interface{}) (string, error) {
+	u, err := uuidParam("uuidVariant(uuid)", 1, x)
+	if err != nil {
+		return "", err
+	}
+	return u.Variant(), nil
+}
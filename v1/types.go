@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"reflect"
+	"sync"
+)
+
+/**
+ * Reflection metadata is cached by type and shared by all programs. Types
+ * are finite in any process, so the caches are never evicted. Member names
+ * come from program source, however, so only the members a type actually
+ * has are cached. Both caches are safe for concurrent use.
+ */
+var (
+	typeCache sync.Map // reflect.Type -> *typeInfo
+	funcCache sync.Map // reflect.Type -> *funcInfo
+)
+
+/**
+ * Reflection metadata for a type that may be dereferenced
+ */
+type typeInfo struct {
+	typ     reflect.Type
+	members sync.Map // string -> *memberEntry
+}
+
+/**
+ * Obtain metadata for a type
+ */
+func typeInfoOf(t reflect.Type) *typeInfo {
+	if v, ok := typeCache.Load(t); ok {
+		return v.(*typeInfo)
+	}
+	v, _ := typeCache.LoadOrStore(t, &typeInfo{typ: t})
+	return v.(*typeInfo)
+}
+
+/**
+ * A resolved member. Either index may be absent, in which case the method
+ * index is negative or the field index is nil, respectively.
+ */
+type memberEntry struct {
+	typ    reflect.Type
+	method int
+	field  []int
+}
+
+/**
+ * Resolve a member of the type, which may be a struct or a pointer to a
+ * struct. Exported methods are resolved on the type itself and fields on
+ * the struct it ultimately refers to, as derefMember expects. A name which
+ * is not a member is resolved again each time; it is not cached, since any
+ * number of such names may be looked up.
+ */
+func (t *typeInfo) member(name string) *memberEntry {
+	if v, ok := t.members.Load(name); ok {
+		return v.(*memberEntry)
+	}
+
+	e := &memberEntry{typ: t.typ, method: -1}
+	if v, ok := t.typ.MethodByName(name); ok {
+		e.method = v.Index
+	}
+
+	b := t.typ
+	for b.Kind() == reflect.Ptr {
+		b = b.Elem()
+	}
+	if b.Kind() == reflect.Struct {
+		if f, ok := b.FieldByName(name); ok {
+			e.field = f.Index
+		}
+	}
+
+	if e.method < 0 && e.field == nil {
+		return e
+	}
+	v, _ := t.members.LoadOrStore(name, e)
+	return v.(*memberEntry)
+}
+
+/**
+ * Reflection metadata for a function type, describing how it is called
+ */
+type funcInfo struct {
+	in       []reflect.Type
+	out      []reflect.Type
+	variadic bool
+	state    bool // the first parameter is *State
+}
+
+/**
+ * Obtain metadata for a function type
+ */
+func funcInfoOf(t reflect.Type) *funcInfo {
+	if v, ok := funcCache.Load(t); ok {
+		return v.(*funcInfo)
+	}
+
+	f := &funcInfo{
+		in:       make([]reflect.Type, t.NumIn()),
+		out:      make([]reflect.Type, t.NumOut()),
+		variadic: t.IsVariadic(),
+	}
+	for i := range f.in {
+		f.in[i] = t.In(i)
+	}
+	for i := range f.out {
+		f.out[i] = t.Out(i)
+	}
+	f.state = len(f.in) > 0 && f.in[0] == typeOfState
+
+	v, _ := funcCache.LoadOrStore(t, f)
+	return v.(*funcInfo)
+}
@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2015 Brian William Wolter, All rights reserved.
+// EPL - A little Embeddable Predicate Language
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+//
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package epl
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/**
+ * A UUID, as described by RFC 9562. A UUID is compared with another UUID or
+ * with a string, which is parsed as a UUID. The UUIDs of other packages,
+ * which are usually also a [16]byte, must be converted to a UUID in order
+ * to be compared as one.
+ */
+type UUID [16]byte
+
+/**
+ * Parse a UUID in its canonical form, like
+ * "9515976f-cdb4-4e56-bd07-b1ae6efc00da", or without hyphens, in either
+ * case. The UUID may also be enclosed in braces or prefixed by "urn:uuid:".
+ */
+func ParseUUID(s string) (UUID, error) {
+	r := s
+	if len(r) == 45 && strings.EqualFold(r[:9], "urn:uuid:") {
+		r = r[9:]
+	} else if len(r) == 38 && r[0] == '{' && r[37] == '}' {
+		r = r[1:37]
+	}
+	switch len(r) {
+	case 36:
+		if r[8] != '-' || r[13] != '-' || r[18] != '-' || r[23] != '-' {
+			return UUID{}, fmt.Errorf("Invalid UUID: %q", s)
+		}
+		r = r[:8] + r[9:13] + r[14:18] + r[19:23] + r[24:]
+	case 32:
+	default:
+		return UUID{}, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	var u UUID
+	if _, err := hex.Decode(u[:], []byte(r)); err != nil {
+		return UUID{}, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	return u, nil
+}
+
+/**
+ * Obtain the UUID described by a UUID identifier, which is 32 hexadecimal
+ * digits with hyphens anywhere among them
+ */
+func uuidFromIdent(ident string) (UUID, error) {
+	var u UUID
+	d := strings.ReplaceAll(ident, "-", "")
+	if len(d) != 32 {
+		return UUID{}, fmt.Errorf("Invalid UUID: %q", ident)
+	}
+	if _, err := hex.Decode(u[:], []byte(d)); err != nil {
+		return UUID{}, fmt.Errorf("Invalid UUID: %q", ident)
+	}
+	return u, nil
+}
+
+/**
+ * Format a UUID in its canonical form
+ */
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+/**
+ * The version of a UUID, which describes how it was generated; e.g., 4
+ * for a random UUID. The version is only meaningful for UUIDs of the
+ * RFC 9562 variant.
+ */
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+/**
+ * The variant of a UUID, which describes its layout: "RFC9562" (formerly
+ * RFC 4122, which is the variant of almost every UUID), "NCS",
+ * "Microsoft", or "Future"
+ */
+func (u UUID) Variant() string {
+	switch {
+	case u[8]&0x80 == 0:
+		return "NCS"
+	case u[8]&0xc0 == 0x80:
+		return "RFC9562"
+	case u[8]&0xe0 == 0xc0:
+		return "Microsoft"
+	default:
+		return "Future"
+	}
+}